@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/webgrip/twitch_exporter/collector"
+)
+
+// Twitch logins are 1-25 characters of letters, digits and underscores.
+var loginPattern = regexp.MustCompile(`^[a-zA-Z0-9_]{1,25}$`)
+
+// watchlistAPI serves the authenticated admin endpoints under /api/watchlist.
+// Changes are applied live; a config reload or restart resets the watchlist
+// to what the flags and config file describe.
+type watchlistAPI struct {
+	logger    *slog.Logger
+	token     string
+	watchlist *collector.SharedWatchlist
+}
+
+type watchlistResponse struct {
+	Self  string   `json:"self"`
+	Watch []string `json:"watch"`
+}
+
+type watchlistEntryResponse struct {
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+func loadAdminToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func (a *watchlistAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/watchlist", a.authenticated(a.list))
+	mux.HandleFunc("GET /api/watchlist/{login}", a.authenticated(a.get))
+	mux.HandleFunc("PUT /api/watchlist/{login}", a.authenticated(a.put))
+	mux.HandleFunc("DELETE /api/watchlist/{login}", a.authenticated(a.delete))
+}
+
+func (a *watchlistAPI) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="twitch_exporter"`)
+			writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *watchlistAPI) list(w http.ResponseWriter, r *http.Request) {
+	wl := a.watchlist.Get()
+	writeJSON(w, http.StatusOK, watchlistResponse{Self: wl.SelfLogin(), Watch: wl.WatchLogins()})
+}
+
+func (a *watchlistAPI) get(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+	role := a.watchlist.Get().RoleLabelForLogin(login)
+	if role == "" {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("channel %q is not in the watchlist", login))
+		return
+	}
+	writeJSON(w, http.StatusOK, watchlistEntryResponse{Login: strings.ToLower(login), Role: role})
+}
+
+func (a *watchlistAPI) put(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+	if !loginPattern.MatchString(login) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid channel login %q", login))
+		return
+	}
+	added, err := a.watchlist.AddWatch(login)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+		a.logger.Info("watch channel added via admin API", "channel", login)
+	}
+	login = strings.ToLower(strings.TrimSpace(login))
+	writeJSON(w, status, watchlistEntryResponse{Login: login, Role: a.watchlist.Get().RoleLabelForLogin(login)})
+}
+
+func (a *watchlistAPI) delete(w http.ResponseWriter, r *http.Request) {
+	login := r.PathValue("login")
+	removed, err := a.watchlist.RemoveWatch(login)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	if !removed {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("channel %q is not in the watchlist", login))
+		return
+	}
+	a.logger.Info("watch channel removed via admin API", "channel", login)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
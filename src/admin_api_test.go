@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/webgrip/twitch_exporter/collector"
+)
+
+func TestWatchlistAPI(t *testing.T) {
+	wl, err := collector.NewChannelWatchlist("me", []string{"alice"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchlist := collector.NewSharedWatchlist(wl)
+	var changes []collector.WatchlistChange
+	watchlist.OnChange(func(c collector.WatchlistChange) { changes = append(changes, c) })
+
+	mux := http.NewServeMux()
+	api := &watchlistAPI{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), token: "secret", watchlist: watchlist}
+	api.register(mux)
+
+	// the cases run in order against the same watchlist
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+		wantWatch  []string
+	}{
+		{name: "no token", method: "GET", path: "/api/watchlist", wantStatus: http.StatusUnauthorized, wantWatch: []string{"alice"}},
+		{name: "wrong token", method: "GET", path: "/api/watchlist", token: "nope", wantStatus: http.StatusUnauthorized, wantWatch: []string{"alice"}},
+		{name: "list", method: "GET", path: "/api/watchlist", token: "secret", wantStatus: http.StatusOK, wantBody: `{"self":"me","watch":["alice"]}`, wantWatch: []string{"alice"}},
+		{name: "get self", method: "GET", path: "/api/watchlist/ME", token: "secret", wantStatus: http.StatusOK, wantBody: `{"login":"me","role":"self"}`, wantWatch: []string{"alice"}},
+		{name: "get unknown", method: "GET", path: "/api/watchlist/bob", token: "secret", wantStatus: http.StatusNotFound, wantWatch: []string{"alice"}},
+		{name: "put", method: "PUT", path: "/api/watchlist/Bob", token: "secret", wantStatus: http.StatusCreated, wantBody: `{"login":"bob","role":"watch"}`, wantWatch: []string{"alice", "bob"}},
+		{name: "put again", method: "PUT", path: "/api/watchlist/bob", token: "secret", wantStatus: http.StatusOK, wantWatch: []string{"alice", "bob"}},
+		{name: "put invalid login", method: "PUT", path: "/api/watchlist/no%20spaces", token: "secret", wantStatus: http.StatusBadRequest, wantWatch: []string{"alice", "bob"}},
+		{name: "put over the limit", method: "PUT", path: "/api/watchlist/carol", token: "secret", wantStatus: http.StatusBadRequest, wantWatch: []string{"alice", "bob"}},
+		{name: "delete self", method: "DELETE", path: "/api/watchlist/me", token: "secret", wantStatus: http.StatusConflict, wantWatch: []string{"alice", "bob"}},
+		{name: "delete", method: "DELETE", path: "/api/watchlist/alice", token: "secret", wantStatus: http.StatusNoContent, wantWatch: []string{"bob"}},
+		{name: "delete unknown", method: "DELETE", path: "/api/watchlist/alice", token: "secret", wantStatus: http.StatusNotFound, wantWatch: []string{"bob"}},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.wantStatus, rec.Body)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tc.wantBody != "" && got != tc.wantBody {
+			t.Errorf("%s: body = %s, want %s", tc.name, got, tc.wantBody)
+		}
+		if got := watchlist.Get().WatchLogins(); !slices.Equal(got, tc.wantWatch) {
+			t.Errorf("%s: watch = %v, want %v", tc.name, got, tc.wantWatch)
+		}
+	}
+
+	if len(changes) != 2 || !slices.Equal(changes[0].Added, []string{"bob"}) || !slices.Equal(changes[1].Removed, []string{"alice"}) {
+		t.Errorf("changes = %+v, want bob added then alice removed", changes)
+	}
+}
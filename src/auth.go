@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	kingpin "github.com/alecthomas/kingpin/v2"
+	"github.com/webgrip/twitch_exporter/collector"
+	"github.com/webgrip/twitch_exporter/internal/oauth"
+	"github.com/webgrip/twitch_exporter/internal/tokenstore"
+)
+
+var (
+	authCmd      = kingpin.Command("auth", "Manage the Twitch user token.")
+	authLoginCmd = authCmd.Command("login", "Obtain a user token with the scopes the enabled collectors need.")
+
+	authLoginFlow = authLoginCmd.Flag("flow",
+		"OAuth flow to use: device (device code) or code (authorization code with a localhost redirect).").
+		Default("device").Enum("device", "code")
+	authLoginListenAddress = authLoginCmd.Flag("listen-address",
+		"Address to receive the authorization code redirect on; http://<address>/ must be a registered redirect URL.").
+		Default("localhost:3000").String()
+	authLoginIDURL = authLoginCmd.Flag("id-url",
+		"Base URL of the Twitch identity service.").Default(oauth.DefaultBaseURL).String()
+	authLoginScopes = authLoginCmd.Flag("scope",
+		"Additional scope to request. Can be provided multiple times.").Strings()
+	authLoginOutput = authLoginCmd.Flag("output",
+		"File to write the token to when no token store is configured (- for stdout).").Default("-").String()
+)
+
+// runAuthLogin requests the user scopes needed by the collectors enabled via
+// flags and --config.file, and stores the resulting token.
+func runAuthLogin(logger *slog.Logger) error {
+	fileCfg, err := loadConfigFile(*configFile)
+	if err != nil {
+		return err
+	}
+	runtimeCfg, err := resolveConfig(fileCfg)
+	if err != nil {
+		return err
+	}
+	if err := collector.SetCollectorOverrides(runtimeCfg.collectors); err != nil {
+		return err
+	}
+
+	scopes := mergeScopes(collector.RequiredUserScopes(), *authLoginScopes)
+	if len(scopes) == 0 {
+		logger.Warn("no enabled collector needs a user token; requesting a token without scopes")
+	}
+	logger.Info("requesting user token", "flow", *authLoginFlow, "scopes", scopes)
+
+	cfg := oauth.Config{
+		ClientID:     *twitchClientID,
+		ClientSecret: *twitchClientSecret,
+		Scopes:       scopes,
+		BaseURL:      *authLoginIDURL,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var token oauth.Token
+	switch *authLoginFlow {
+	case "code":
+		token, err = cfg.AuthCodeLogin(ctx, *authLoginListenAddress, func(authURL string) {
+			fmt.Fprintf(os.Stderr, "Open this URL in your browser to authorize the exporter:\n\n  %s\n\n", authURL)
+		})
+	default:
+		token, err = cfg.DeviceLogin(ctx, func(verificationURI, userCode string) {
+			fmt.Fprintf(os.Stderr, "Open %s and enter the code %s to authorize the exporter.\n", verificationURI, userCode)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	stored := tokenstore.Token{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Scopes:       token.Scopes,
+	}
+	if token.ExpiresIn > 0 {
+		stored.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if missing := missingScopes(scopes, token.Scopes); len(missing) > 0 {
+		logger.Warn("token was granted without some requested scopes", "missing", missing)
+	}
+
+	store, err := newTokenStore()
+	if err != nil {
+		return err
+	}
+	if store == nil && *authLoginOutput != "-" {
+		store = tokenstore.NewFileStore(*authLoginOutput)
+	}
+	if store == nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stored)
+	}
+	if err := store.Save(stored); err != nil {
+		return err
+	}
+	logger.Info("user token saved")
+	return nil
+}
+
+func mergeScopes(lists ...[]string) []string {
+	set := map[string]struct{}{}
+	for _, list := range lists {
+		for _, scope := range list {
+			set[scope] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for scope := range set {
+		out = append(out, scope)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func missingScopes(requested []string, granted []string) []string {
+	have := map[string]struct{}{}
+	for _, scope := range granted {
+		have[scope] = struct{}{}
+	}
+	var missing []string
+	for _, scope := range requested {
+		if _, ok := have[scope]; !ok {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// APIError is a non-2xx Helix response. Collectors return it instead of the
+// bare error message so the status survives to ClassifyErrorReason.
+type APIError struct {
+	// Endpoint is the Helix path without the leading slash, e.g. "streams".
+	Endpoint   string
+	StatusCode int
+	// Code is the error name Twitch sends alongside the status, e.g.
+	// "Unauthorized" or "Too Many Requests".
+	Code    string
+	Message string
+	// RetryAfter is how long until the rate-limit bucket resets; 0 if the
+	// response did not say.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Code
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("helix %s returned status %d: %s", e.Endpoint, e.StatusCode, msg)
+}
+
+// checkResponse returns an *APIError for non-2xx responses and nil otherwise.
+func checkResponse(endpoint string, rc helix.ResponseCommon) error {
+	if rc.StatusCode >= 200 && rc.StatusCode < 300 {
+		return nil
+	}
+	e := &APIError{
+		Endpoint:   endpoint,
+		StatusCode: rc.StatusCode,
+		Code:       rc.Error,
+		Message:    rc.ErrorMessage,
+	}
+	if reset, err := strconv.ParseInt(rc.Header.Get("Ratelimit-Reset"), 10, 64); err == nil {
+		e.RetryAfter = max(time.Until(time.Unix(reset, 0)), 0)
+	} else if secs, err := strconv.Atoi(rc.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestClassifyErrorReason(t *testing.T) {
+	var syntaxErr error
+	if err := json.Unmarshal([]byte("{"), &struct{}{}); err != nil {
+		syntaxErr = err
+	}
+	var typeErr error
+	if err := json.Unmarshal([]byte(`{"a":"x"}`), &struct{ A int }{}); err != nil {
+		typeErr = err
+	}
+
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, "other"},
+		{"429", &APIError{StatusCode: http.StatusTooManyRequests}, "rate_limited"},
+		{"401", &APIError{StatusCode: http.StatusUnauthorized}, "auth"},
+		{"403", &APIError{StatusCode: http.StatusForbidden}, "auth"},
+		{"404", &APIError{StatusCode: http.StatusNotFound}, "http_4xx"},
+		{"400", &APIError{StatusCode: http.StatusBadRequest}, "http_4xx"},
+		{"500", &APIError{StatusCode: http.StatusInternalServerError}, "http_5xx"},
+		{"503", &APIError{StatusCode: http.StatusServiceUnavailable}, "http_5xx"},
+		{"wrapped api error", fmt.Errorf("collecting: %w", &APIError{StatusCode: http.StatusTooManyRequests}), "rate_limited"},
+		{"deadline", context.DeadlineExceeded, "timeout"},
+		{"wrapped deadline", fmt.Errorf("get: %w", context.DeadlineExceeded), "timeout"},
+		{"net timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, "timeout"},
+		{"json syntax", syntaxErr, "decode"},
+		{"json type", typeErr, "decode"},
+		{"canceled", context.Canceled, "other"},
+		{"plain", errors.New("boom"), "other"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ClassifyErrorReason(tc.err); got != tc.want {
+				t.Errorf("ClassifyErrorReason(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		name       string
+		rc         helix.ResponseCommon
+		wantErr    bool
+		wantStatus int
+		// wantRetry is the expected RetryAfter, give or take a second.
+		wantRetry time.Duration
+	}{
+		{name: "200", rc: helix.ResponseCommon{StatusCode: 200}},
+		{name: "204", rc: helix.ResponseCommon{StatusCode: 204}},
+		{
+			name:    "401",
+			rc:      helix.ResponseCommon{StatusCode: 401, Error: "Unauthorized", ErrorMessage: "Invalid OAuth token"},
+			wantErr: true, wantStatus: 401,
+		},
+		{
+			name: "429 with reset",
+			rc: helix.ResponseCommon{StatusCode: 429, Header: http.Header{
+				"Ratelimit-Reset": {strconv.FormatInt(time.Now().Add(30*time.Second).Unix(), 10)},
+			}},
+			wantErr: true, wantStatus: 429, wantRetry: 30 * time.Second,
+		},
+		{
+			name: "429 with reset in the past",
+			rc: helix.ResponseCommon{StatusCode: 429, Header: http.Header{
+				"Ratelimit-Reset": {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+			}},
+			wantErr: true, wantStatus: 429,
+		},
+		{
+			name:    "503 with retry-after",
+			rc:      helix.ResponseCommon{StatusCode: 503, Header: http.Header{"Retry-After": {"7"}}},
+			wantErr: true, wantStatus: 503, wantRetry: 7 * time.Second,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkResponse("streams", tc.rc)
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want *APIError", err)
+			}
+			if apiErr.StatusCode != tc.wantStatus || apiErr.Endpoint != "streams" {
+				t.Errorf("got status %d endpoint %q", apiErr.StatusCode, apiErr.Endpoint)
+			}
+			if d := apiErr.RetryAfter - tc.wantRetry; d < -time.Second || d > time.Second {
+				t.Errorf("RetryAfter = %s, want about %s", apiErr.RetryAfter, tc.wantRetry)
+			}
+		})
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	cases := []struct {
+		err  *APIError
+		want string
+	}{
+		{&APIError{Endpoint: "users", StatusCode: 401, Code: "Unauthorized", Message: "Invalid OAuth token"}, "helix users returned status 401: Invalid OAuth token"},
+		{&APIError{Endpoint: "users", StatusCode: 429, Code: "Too Many Requests"}, "helix users returned status 429: Too Many Requests"},
+		{&APIError{Endpoint: "users", StatusCode: 502}, "helix users returned status 502: Bad Gateway"},
+	}
+	for _, tc := range cases {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("Error() = %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nicklaw5/helix/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// helixBatchSize is the maximum number of logins or IDs Helix accepts per request.
+const helixBatchSize = 100
+
+var apiConcurrency atomic.Int32
+
+func init() {
+	apiConcurrency.Store(4)
+}
+
+// SetAPIConcurrency bounds how many Helix requests a single collector runs in parallel.
+func SetAPIConcurrency(n int) {
+	if n <= 0 {
+		n = 1
+	}
+	apiConcurrency.Store(int32(n))
+}
+
+// forEachConcurrent calls fn for every item with bounded concurrency and
+// returns the first error. Helix requests cannot be cancelled once sent, so
+// items not yet started are skipped when ctx is done.
+func forEachConcurrent[T any](ctx context.Context, items []T, fn func(T) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(int(apiConcurrency.Load()))
+	for _, item := range items {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(item)
+		})
+	}
+	return g.Wait()
+}
+
+// forEachBatch splits logins into Helix-sized batches and calls fn for each
+// batch with bounded concurrency.
+func forEachBatch(ctx context.Context, logins []string, fn func(batch []string) error) error {
+	return forEachConcurrent(ctx, chunkStrings(logins, helixBatchSize), fn)
+}
+
+// getStreamsBatched returns the live streams for the given logins keyed by
+// normalized login. Helix only returns streams that are currently live.
+func getStreamsBatched(ctx context.Context, client *helix.Client, logins []string) (map[string]helix.Stream, error) {
+	client = withContext(ctx, client)
+	var mu sync.Mutex
+	streams := map[string]helix.Stream{}
+	err := forEachBatch(ctx, logins, func(batch []string) error {
+		resp, err := client.GetStreams(&helix.StreamsParams{UserLogins: batch, First: len(batch)})
+		if err != nil {
+			return err
+		}
+		if err := checkResponse("streams", resp.ResponseCommon); err != nil {
+			return err
+		}
+		mu.Lock()
+		for _, s := range resp.Data.Streams {
+			streams[normalizeLogin(s.UserLogin)] = s
+		}
+		mu.Unlock()
+		return nil
+	})
+	return streams, err
+}
@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+func TestGetStreamsBatched(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		sizes []int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins := r.URL.Query()["user_login"]
+		mu.Lock()
+		sizes = append(sizes, len(logins))
+		mu.Unlock()
+		var streams []helix.Stream
+		for _, login := range logins {
+			// every tenth channel is live
+			if strings.HasSuffix(login, "0") {
+				streams = append(streams, helix.Stream{UserLogin: strings.ToUpper(login), ID: login})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": streams})
+	}))
+	defer srv.Close()
+	client, err := helix.NewClient(&helix.Options{ClientID: "client", AppAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logins := make([]string, 250)
+	for i := range logins {
+		logins[i] = fmt.Sprintf("user%d", i)
+	}
+	streams, err := getStreamsBatched(context.Background(), client, logins)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(sizes)
+	if !slices.Equal(sizes, []int{50, 100, 100}) {
+		t.Errorf("request sizes = %v, want pages of at most 100", sizes)
+	}
+	if len(streams) != 25 {
+		t.Errorf("got %d live streams, want 25", len(streams))
+	}
+	if s, ok := streams["user240"]; !ok || s.ID != "user240" {
+		t.Errorf("stream of user240 = %+v, %v; want it keyed by normalized login", s, ok)
+	}
+}
+
+func TestChunkStrings(t *testing.T) {
+	cases := []struct {
+		n, size int
+		want    []int
+	}{
+		{0, 100, nil},
+		{1, 100, []int{1}},
+		{100, 100, []int{100}},
+		{101, 100, []int{100, 1}},
+		{3, 0, []int{1, 1, 1}},
+	}
+	for _, tc := range cases {
+		var got []int
+		for _, chunk := range chunkStrings(make([]string, tc.n), tc.size) {
+			got = append(got, len(chunk))
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("chunkStrings(%d, %d) sizes = %v, want %v", tc.n, tc.size, got, tc.want)
+		}
+	}
+}
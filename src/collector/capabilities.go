@@ -1,6 +1,9 @@
 package collector
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 var (
 	capMu sync.RWMutex
@@ -31,6 +34,50 @@ var KnownUserScopes = []string{
 	"chat:read",
 }
 
+// collectorUserScopes lists the user OAuth scopes each collector needs for
+// full coverage. Collectors not listed work with an app access token.
+var collectorUserScopes = map[string][]string{
+	"channel_subscribers_total":   {"channel:read:subscriptions"},
+	"channel_chat_messages_total": {"user:read:chat"},
+	"eventsub_self": {
+		"bits:read",
+		"channel:read:ads",
+		"channel:read:charity",
+		"channel:read:goals",
+		"channel:read:hype_train",
+		"channel:read:polls",
+		"channel:read:predictions",
+		"channel:read:redemptions",
+		"channel:read:subscriptions",
+		"moderation:read",
+		"moderator:read:followers",
+	},
+}
+
+// RequiredUserScopes returns the sorted user scopes needed by the enabled
+// collectors, taking flags and config file overrides into account.
+func RequiredUserScopes() []string {
+	initiatedCollectorsMtx.Lock()
+	overrides := collectorOverrides
+	initiatedCollectorsMtx.Unlock()
+
+	set := map[string]struct{}{}
+	for name, scopes := range collectorUserScopes {
+		if !collectorEnabled(name, overrides) {
+			continue
+		}
+		for _, scope := range scopes {
+			set[scope] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for scope := range set {
+		out = append(out, scope)
+	}
+	sort.Strings(out)
+	return out
+}
+
 func SetCapabilities(appTokenPresent bool, userTokenPresent bool, userScopes []string) {
 	s := map[string]struct{}{}
 	for _, scope := range userScopes {
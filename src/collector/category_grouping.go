@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	categoryGroupMu sync.RWMutex
+	categoryGroups  = categoryGroupConfig{
+		defaultGroup: "none",
+		unknownGroup: "other",
+		maxGroups:    20,
+		byID:         map[string]string{},
+		byName:       map[string]string{},
+		groups:       map[string]bool{"none": true, "other": true},
+	}
+)
+
+type categoryGroupConfig struct {
+	defaultGroup string
+	unknownGroup string
+	maxGroups    int
+
+	byID   map[string]string
+	byName map[string]string
+	// groups are all labels the grouping can produce.
+	groups map[string]bool
+}
+
+// CategoryGrouping is a validated category grouping that can be applied
+// later, so a config reload can validate everything before changing anything.
+type CategoryGrouping struct {
+	cfg categoryGroupConfig
+}
+
+func NewCategoryGrouping(defaultGroup string, unknownGroup string, maxGroups int, byID map[string]string, byName map[string]string) (CategoryGrouping, error) {
+	if strings.TrimSpace(defaultGroup) == "" {
+		defaultGroup = "none"
+	}
+	if strings.TrimSpace(unknownGroup) == "" {
+		unknownGroup = "other"
+	}
+	if maxGroups <= 0 {
+		maxGroups = 20
+	}
+
+	groups := map[string]struct{}{defaultGroup: {}, unknownGroup: {}}
+	for _, g := range byID {
+		groups[g] = struct{}{}
+	}
+	for _, g := range byName {
+		groups[g] = struct{}{}
+	}
+	if len(groups) > maxGroups {
+		keys := make([]string, 0, len(groups))
+		for k := range groups {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return CategoryGrouping{}, fmt.Errorf("category_group cardinality too high: %d groups (max %d): %v", len(groups), maxGroups, keys)
+	}
+
+	normID := map[string]string{}
+	for k, v := range byID {
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		if k == "" || v == "" {
+			continue
+		}
+		normID[k] = v
+	}
+
+	normName := map[string]string{}
+	for k, v := range byName {
+		k = normalizeTitle(k)
+		v = strings.TrimSpace(v)
+		if k == "" || v == "" {
+			continue
+		}
+		normName[k] = v
+	}
+
+	labels := map[string]bool{defaultGroup: true, unknownGroup: true}
+	for _, g := range normID {
+		labels[g] = true
+	}
+	for _, g := range normName {
+		labels[g] = true
+	}
+
+	return CategoryGrouping{cfg: categoryGroupConfig{
+		defaultGroup: defaultGroup,
+		unknownGroup: unknownGroup,
+		maxGroups:    maxGroups,
+		byID:         normID,
+		byName:       normName,
+		groups:       labels,
+	}}, nil
+}
+
+func ApplyCategoryGrouping(g CategoryGrouping) {
+	categoryGroupMu.Lock()
+	defer categoryGroupMu.Unlock()
+	categoryGroups = g.cfg
+}
+
+// CurrentCategoryGrouping returns the grouping in use, e.g. to restore it.
+func CurrentCategoryGrouping() CategoryGrouping {
+	categoryGroupMu.RLock()
+	defer categoryGroupMu.RUnlock()
+	return CategoryGrouping{cfg: categoryGroups}
+}
+
+// foldCategoryGroups moves the values of groups the current grouping cannot
+// produce, left over from before a reload, into its unknown group. Counters
+// kept per group so stay within the max.
+func foldCategoryGroups(values map[string]float64) {
+	categoryGroupMu.RLock()
+	cfg := categoryGroups
+	categoryGroupMu.RUnlock()
+
+	for group, v := range values {
+		if cfg.groups[group] {
+			continue
+		}
+		delete(values, group)
+		values[cfg.unknownGroup] += v
+	}
+}
+
+// CategoryGroupFor maps a category to its category_group label. The ID wins
+// over the name, which is matched case-insensitively; a channel without a
+// category gets the default group.
+func CategoryGroupFor(categoryID string, categoryName string) string {
+	categoryGroupMu.RLock()
+	cfg := categoryGroups
+	categoryGroupMu.RUnlock()
+
+	categoryID = strings.TrimSpace(categoryID)
+	if categoryID != "" {
+		if g, ok := cfg.byID[categoryID]; ok {
+			return g
+		}
+	}
+
+	name := normalizeTitle(categoryName)
+	if name != "" {
+		if g, ok := cfg.byName[name]; ok {
+			return g
+		}
+	}
+
+	if categoryID == "" && name == "" {
+		return cfg.defaultGroup
+	}
+	return cfg.unknownGroup
+}
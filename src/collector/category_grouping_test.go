@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"maps"
+	"testing"
+)
+
+func applyTestCategoryGrouping(t *testing.T, unknown string, byID map[string]string, byName map[string]string) {
+	t.Helper()
+	g, err := NewCategoryGrouping("none", unknown, 5, byID, byName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categoryGroupMu.RLock()
+	prev := categoryGroups
+	categoryGroupMu.RUnlock()
+	ApplyCategoryGrouping(g)
+	t.Cleanup(func() { ApplyCategoryGrouping(CategoryGrouping{cfg: prev}) })
+}
+
+func TestCategoryGroupFor(t *testing.T) {
+	applyTestCategoryGrouping(t, "other", map[string]string{"509658": "chatting"}, map[string]string{"Just Chatting": "chatting", "Minecraft": "games"})
+	cases := []struct {
+		id, name string
+		want     string
+	}{
+		{"509658", "", "chatting"},
+		{"", "just chatting", "chatting"},
+		{"27471", "MINECRAFT", "games"},
+		// the ID wins over the name
+		{"509658", "Minecraft", "chatting"},
+		{"1", "Unmapped", "other"},
+		{"", "", "none"},
+	}
+	for _, tc := range cases {
+		if got := CategoryGroupFor(tc.id, tc.name); got != tc.want {
+			t.Errorf("CategoryGroupFor(%q, %q) = %q, want %q", tc.id, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFoldCategoryGroups(t *testing.T) {
+	cases := []struct {
+		name    string
+		unknown string
+		byID    map[string]string
+		values  map[string]float64
+		want    map[string]float64
+	}{
+		{
+			name:   "all groups still mapped",
+			byID:   map[string]string{"1": "games"},
+			values: map[string]float64{"games": 10, "other": 5, "none": 1},
+			want:   map[string]float64{"games": 10, "other": 5, "none": 1},
+		},
+		{
+			name:   "removed group folds into unknown",
+			byID:   map[string]string{"1": "games"},
+			values: map[string]float64{"games": 10, "chatting": 7, "other": 5},
+			want:   map[string]float64{"games": 10, "other": 12},
+		},
+		{
+			name:    "renamed unknown group",
+			unknown: "misc",
+			values:  map[string]float64{"other": 5, "old": 2},
+			want:    map[string]float64{"misc": 7},
+		},
+		{name: "empty", values: map[string]float64{}, want: map[string]float64{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			applyTestCategoryGrouping(t, tc.unknown, tc.byID, nil)
+			foldCategoryGroups(tc.values)
+			if !maps.Equal(tc.values, tc.want) {
+				t.Errorf("got %v, want %v", tc.values, tc.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"sync"
@@ -35,6 +36,14 @@ func (m MessageCounter) Reset(username string, chatterUsername string) {
 	chatMessages[username][chatterUsername] = 0
 }
 
+// Delete drops all counts for a channel.
+func (m MessageCounter) Delete(username string) {
+	chatMessagesMutex.Lock()
+	defer chatMessagesMutex.Unlock()
+
+	delete(chatMessages, username)
+}
+
 // ensure ensures that the username and chatterUsername exist in the map
 func (m MessageCounter) ensure(username string, chatterUsername string) {
 	if _, ok := chatMessages[username]; !ok {
@@ -58,7 +67,11 @@ func (m MessageCounter) Get(username string, chatterUsername string) int {
 type ChannelChatMessagesCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	eventsub  *eventsub.Client
+	watchlist *SharedWatchlist
+
+	mu             sync.Mutex
+	broadcasterIDs map[string]string // login -> user id of subscribed broadcasters
 
 	channelChatMessages typedDesc
 }
@@ -69,26 +82,13 @@ func init() {
 	registerCollector("channel_chat_messages_total", defaultDisabled, NewChannelChatMessagesCollector)
 }
 
-func NewChannelChatMessagesCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelChatMessagesCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	// this means that eventsub.enabled must be true, otherwise the default client will not be set
 	if eventsubClient == nil {
 		return nil, eventsub.ErrEventsubClientNotSet
 	}
 
-	broadcasterIDs := []string{}
-	logins := watchlist.AllLogins()
-	users, err := client.GetUsers(&helix.UsersParams{
-		Logins: logins,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	for _, user := range users.Data.Users {
-		broadcasterIDs = append(broadcasterIDs, user.ID)
-	}
-
-	err = eventsubClient.On("channel.chat.message", func(eventRaw json.RawMessage) {
+	err := eventsubClient.On("channel.chat.message", func(eventRaw json.RawMessage) {
 		var event eventsub.ChannelChatMessageEvent
 
 		if err := json.Unmarshal(eventRaw, &event); err != nil {
@@ -103,30 +103,16 @@ func NewChannelChatMessagesCollector(logger *slog.Logger, client *helix.Client,
 			"count", chatMessages.Get(event.BroadcasterUserLogin, event.ChatterUserLogin),
 		)
 	})
-
-	// todo: we can only subscribe to broadcasters with an access token and refresh token, so this
-	// would generally just be a single user, the broadcaster
-	for _, broadcasterID := range broadcasterIDs {
-		err = eventsubClient.SubscribeApp(
-			"channel.chat.message",
-			"1",
-			helix.EventSubCondition{BroadcasterUserID: broadcasterID, UserID: broadcasterID},
-		)
-		if err != nil {
-			logger.Error("failed to subscribe to channel chat messages", "error", err)
-		}
-	}
-
-	// in theory the only error this could be is ErrEventsubDefaultClientNotSet which is already handled
-	// but it returns an error in case that expands
 	if err != nil {
 		return nil, err
 	}
 
-	c := ChannelChatMessagesCollector{
-		logger:    logger,
-		client:    client,
-		watchlist: watchlist,
+	c := &ChannelChatMessagesCollector{
+		logger:         logger,
+		client:         client,
+		eventsub:       eventsubClient,
+		watchlist:      watchlist,
+		broadcasterIDs: map[string]string{},
 
 		// we keep the use of the username as the label to avoid adding a bunch of duplicate labels under
 		// a new name of broadcaster_username, which would just match with the other metrics using username
@@ -140,15 +126,81 @@ func NewChannelChatMessagesCollector(logger *slog.Logger, client *helix.Client,
 		), prometheus.GaugeValue},
 	}
 
+	// in theory the only error this could be is ErrEventsubDefaultClientNotSet which is already handled
+	// but it returns an error in case that expands
+	if err := c.subscribe(watchlist.Get().AllLogins()); err != nil {
+		return nil, err
+	}
+
+	// channels added or removed at runtime get their subscriptions and counters adjusted
+	watchlist.OnChange(func(change WatchlistChange) {
+		if err := c.subscribe(change.Added); err != nil {
+			logger.Error("failed to subscribe added channels to chat messages", "error", err)
+		}
+		c.unsubscribe(change.Removed)
+	})
+
 	return c, nil
 }
 
-func (c ChannelChatMessagesCollector) Update(ch chan<- prometheus.Metric) error {
-	if len(c.watchlist.AllLogins()) == 0 {
+// subscribe creates channel.chat.message subscriptions for the given logins and
+// returns the last subscription error, if any.
+func (c *ChannelChatMessagesCollector) subscribe(logins []string) error {
+	if len(logins) == 0 {
+		return nil
+	}
+	users, err := resolveUsers(context.Background(), c.client, logins)
+	if err != nil {
+		return err
+	}
+
+	// todo: we can only subscribe to broadcasters with an access token and refresh token, so this
+	// would generally just be a single user, the broadcaster
+	var subErr error
+	for _, user := range users {
+		c.mu.Lock()
+		c.broadcasterIDs[normalizeLogin(user.Login)] = user.ID
+		c.mu.Unlock()
+
+		subErr = c.eventsub.SubscribeApp(
+			"channel.chat.message",
+			"1",
+			helix.EventSubCondition{BroadcasterUserID: user.ID, UserID: user.ID},
+		)
+		if subErr != nil {
+			c.logger.Error("failed to subscribe to channel chat messages", "error", subErr)
+		}
+	}
+	return subErr
+}
+
+// unsubscribe drops subscriptions and counters for channels removed from the watchlist.
+func (c *ChannelChatMessagesCollector) unsubscribe(logins []string) {
+	for _, login := range logins {
+		chatMessages.Delete(login)
+
+		c.mu.Lock()
+		id, ok := c.broadcasterIDs[login]
+		delete(c.broadcasterIDs, login)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		err := c.eventsub.Unsubscribe("channel.chat.message", helix.EventSubCondition{BroadcasterUserID: id, UserID: id})
+		if err != nil {
+			c.logger.Error("failed to unsubscribe from channel chat messages", "error", err)
+		}
+	}
+}
+
+func (c *ChannelChatMessagesCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if len(c.watchlist.Get().AllLogins()) == 0 {
 		return ErrNoData
 	}
 
 	// loop all the channels and push the counts
+	chatMessagesMutex.Lock()
+	defer chatMessagesMutex.Unlock()
 	for username, count := range chatMessages {
 		for chatterUsername, count := range count {
 			ch <- prometheus.MustNewConstMetric(c.channelChatMessages.desc, prometheus.CounterValue, float64(count), username, chatterUsername)
@@ -1,56 +1,150 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
 	"log/slog"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nicklaw5/helix/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webgrip/twitch_exporter/internal/eventsub"
+	"github.com/webgrip/twitch_exporter/internal/sessions"
 )
 
+// eventGrace is how long after an EventSub notification the poller leaves a
+// channel's transitions alone: Helix may still serve the state from before
+// the event, which would count the change back and forth.
+const eventGrace = 2 * time.Minute
+
+// maxScrapeSampleGap is the longest time between two polls that is credited
+// when channel_core runs on every scrape, where its cadence is unknown. With
+// an interval the limit is twice the interval.
+const maxScrapeSampleGap = 5 * time.Minute
+
+var channelCoreEventSub atomic.Bool
+
+// SetChannelCoreEventSub makes channel_core consume channel.update and
+// stream.online/offline notifications, so every change is counted even when
+// several happen between two polls or while the channel is offline.
+func SetChannelCoreEventSub(enabled bool) {
+	channelCoreEventSub.Store(enabled)
+}
+
 type channelCoreState struct {
-	live             bool
+	live bool
+	// streamID and startedAt identify the current session.
+	streamID         string
 	startedAt        time.Time
 	lastTitle        string
 	lastCategoryID   string
+	lastCategoryName string
 	lastTransitionAt time.Time
+	// lastEventAt is when a notification last changed the state.
+	lastEventAt time.Time
 
 	streamStarts    float64
 	streamEnds      float64
+	streamRestarts  float64
 	titleChanges    float64
 	categoryChanges float64
+
+	// watchSeconds integrates the viewer count over the time between two
+	// polls of the same stream, and groupSeconds credits that time to the
+	// category_group of the earlier poll; sample is the last poll taken.
+	watchSeconds float64
+	groupSeconds map[string]float64
+	sample       viewerSample
+}
+
+type viewerSample struct {
+	streamID     string
+	viewers      int
+	categoryID   string
+	categoryName string
+	at           time.Time
+}
+
+// integrate adds the viewer-seconds since the previous sample of the same
+// stream, interpolating linearly between the two viewer counts. Samples of
+// another stream, after an offline gap or a restart, start over instead, and
+// so do samples more than maxGap apart: nothing is known about the viewers in
+// between (failed polls, a stalled exporter).
+func (st *channelCoreState) integrate(s viewerSample, maxGap time.Duration) {
+	prev := st.sample
+	st.sample = s
+	if prev.streamID == "" || prev.streamID != s.streamID {
+		return
+	}
+	gap := s.at.Sub(prev.at)
+	if gap <= 0 || gap > maxGap {
+		return
+	}
+	dt := gap.Seconds()
+	st.watchSeconds += float64(prev.viewers+s.viewers) / 2 * dt
+	if st.groupSeconds == nil {
+		st.groupSeconds = map[string]float64{}
+	}
+	st.groupSeconds[CategoryGroupFor(prev.categoryID, prev.categoryName)] += dt
 }
 
 type channelCoreCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
+	eventsub  *eventsub.Client
+	sessions  *sessions.Tracker
+	// maxGap is the longest time between two polls credited by integrate,
+	// see setInterval.
+	maxGap atomic.Int64
 
+	mu    sync.Mutex
 	state map[string]*channelCoreState
+	// ids maps logins to the user IDs their subscriptions were created for.
+	ids map[string]string
+
+	channelLive                      typedDesc
+	channelViewers                   typedDesc
+	channelStreamStartedAt           typedDesc
+	channelStreamUptime              typedDesc
+	channelCategoryID                typedDesc
+	channelCategoryGroupInfo         typedDesc
+	channelCategoryGroupSecondsTotal typedDesc
+	channelTitleChangeTotal          typedDesc
+	channelCategoryChangeTotal       typedDesc
+	channelStreamStartsTotal         typedDesc
+	channelStreamEndsTotal           typedDesc
+	channelStreamRestartsTotal       typedDesc
+	channelWatchSecondsTotal         typedDesc
 
-	channelLive                typedDesc
-	channelViewers             typedDesc
-	channelStreamStartedAt     typedDesc
-	channelStreamUptime        typedDesc
-	channelCategoryID          typedDesc
-	channelTitleChangeTotal    typedDesc
-	channelCategoryChangeTotal typedDesc
-	channelStreamStartsTotal   typedDesc
-	channelStreamEndsTotal     typedDesc
+	lastStreamPeakViewers    typedDesc
+	lastStreamAverageViewers typedDesc
+	lastStreamDuration       typedDesc
+	lastStreamStartedAt      typedDesc
+	lastStreamEndedAt        typedDesc
+	lastStreamCategories     typedDesc
 }
 
 func init() {
 	registerCollector("channel_core", defaultEnabled, NewChannelCoreCollector)
 }
 
-func NewChannelCoreCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelCoreCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
+	return newChannelCoreCollector(logger, client, eventsubClient, watchlist, SessionTracker())
+}
+
+// newChannelCoreCollector records stream sessions in tracker, if not nil.
+func newChannelCoreCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist, tracker *sessions.Tracker) (Collector, error) {
 	c := &channelCoreCollector{
 		logger:    logger,
 		client:    client,
 		watchlist: watchlist,
 		state:     map[string]*channelCoreState{},
+		ids:       map[string]string{},
+		sessions:  tracker,
 		channelLive: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "channel_live"),
 			"Whether the channel is currently live (1 = live, 0 = offline).",
@@ -71,6 +165,18 @@ func NewChannelCoreCollector(logger *slog.Logger, client *helix.Client, eventsub
 			"Stream uptime in seconds (0 when offline).",
 			[]string{"channel", "role"}, nil,
 		), prometheus.GaugeValue},
+		channelCategoryGroupInfo: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_category_group_info"),
+			"Category group of the live channel's current category (always 1).",
+			[]string{"channel", "role", "category_group"}, nil,
+		), prometheus.GaugeValue},
+
+		channelCategoryGroupSecondsTotal: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_category_group_seconds_total"),
+			"Total seconds streamed per category group, measured between polls of the same stream.",
+			[]string{"channel", "role", "category_group"}, nil,
+		), prometheus.CounterValue},
+
 		channelCategoryID: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "channel_category_id"),
 			"Current category/game numeric ID for the channel (0 when offline/unknown).",
@@ -96,12 +202,215 @@ func NewChannelCoreCollector(logger *slog.Logger, client *helix.Client, eventsub
 			"Total number of observed stream end transitions (live -> offline).",
 			[]string{"channel", "role"}, nil,
 		), prometheus.CounterValue},
+		channelStreamRestartsTotal: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_stream_restarts_total"),
+			"Total number of streams replaced by a new stream without an observed end (stream ID changed while live).",
+			[]string{"channel", "role"}, nil,
+		), prometheus.CounterValue},
+
+		channelWatchSecondsTotal: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_watch_seconds_total"),
+			"Total viewer-seconds watched, integrated from the viewer count between polls of the same stream.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.CounterValue},
+
+		lastStreamPeakViewers: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_peak_viewers"),
+			"Peak viewer count of the channel's last finished stream.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		lastStreamAverageViewers: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_average_viewers"),
+			"Time-weighted average viewer count of the channel's last finished stream.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		lastStreamDuration: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_duration_seconds"),
+			"Duration of the channel's last finished stream.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		lastStreamStartedAt: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_started_at_seconds"),
+			"Unix timestamp when the channel's last finished stream started.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		lastStreamEndedAt: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_ended_at_seconds"),
+			"Unix timestamp when the end of the channel's last finished stream was observed.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		lastStreamCategories: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_last_stream_categories"),
+			"Number of distinct categories the channel's last finished stream visited.",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+	}
+	c.setInterval(0)
+
+	if channelCoreEventSub.Load() && eventsubClient != nil && client != nil {
+		c.eventsub = eventsubClient
+		c.on("channel.update", c.onChannelUpdate)
+		c.on("stream.online", c.onStreamOnline)
+		c.on("stream.offline", c.onStreamOffline)
+		c.subscribe(watchlist.Get().AllLogins())
+		watchlist.OnChange(func(change WatchlistChange) {
+			c.subscribe(change.Added)
+			c.unsubscribe(change.Removed)
+		})
 	}
 	return c, nil
 }
 
-func (c *channelCoreCollector) Update(ch chan<- prometheus.Metric) error {
-	logins := c.watchlist.AllLogins()
+// setInterval bounds the time integrate credits between two polls to twice
+// the interval, so one late run still counts.
+func (c *channelCoreCollector) setInterval(interval time.Duration) {
+	gap := 2 * interval
+	if interval == 0 {
+		gap = maxScrapeSampleGap
+	}
+	c.maxGap.Store(int64(gap))
+}
+
+var channelCoreSubscriptions = []struct {
+	eventType string
+	version   string
+}{
+	{"channel.update", "2"},
+	{"stream.online", "1"},
+	{"stream.offline", "1"},
+}
+
+// subscribe creates the channel_core subscriptions for the given logins.
+// Failures are left to the reconciler; the poller covers the gap.
+func (c *channelCoreCollector) subscribe(logins []string) {
+	if len(logins) == 0 {
+		return
+	}
+	users, err := resolveUsers(context.Background(), c.client, logins)
+	if err != nil {
+		c.logger.Error("failed to resolve channels for channel_core eventsub", "err", err)
+		return
+	}
+	for _, user := range users {
+		c.mu.Lock()
+		c.ids[normalizeLogin(user.Login)] = user.ID
+		c.mu.Unlock()
+		for _, s := range channelCoreSubscriptions {
+			err := c.eventsub.SubscribeApp(s.eventType, s.version, helix.EventSubCondition{BroadcasterUserID: user.ID})
+			if err != nil {
+				c.logger.Warn("failed to subscribe to eventsub", "event_type", s.eventType, "channel", user.Login, "err", err)
+			}
+		}
+	}
+}
+
+func (c *channelCoreCollector) unsubscribe(logins []string) {
+	for _, login := range logins {
+		c.mu.Lock()
+		id, ok := c.ids[login]
+		delete(c.ids, login)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		for _, s := range channelCoreSubscriptions {
+			if err := c.eventsub.Unsubscribe(s.eventType, helix.EventSubCondition{BroadcasterUserID: id}); err != nil {
+				c.logger.Warn("failed to unsubscribe from eventsub", "event_type", s.eventType, "channel", login, "err", err)
+			}
+		}
+	}
+}
+
+// channelCoreEvent holds the fields channel_core reads from channel.update and
+// stream.online/offline events.
+type channelCoreEvent struct {
+	BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+	ID                   string    `json:"id"`
+	Title                string    `json:"title"`
+	CategoryID           string    `json:"category_id"`
+	CategoryName         string    `json:"category_name"`
+	StartedAt            time.Time `json:"started_at"`
+}
+
+// on calls fn with the state of a watched channel under c.mu. Events for
+// channels not on the watchlist, e.g. those of eventsub_watch after a
+// removal, are ignored.
+func (c *channelCoreCollector) on(eventType string, fn func(st *channelCoreState, ev channelCoreEvent, now time.Time)) {
+	_ = c.eventsub.On(eventType, func(raw json.RawMessage) {
+		var ev channelCoreEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			c.logger.Warn("failed to decode eventsub event", "event_type", eventType, "err", err)
+			return
+		}
+		login := normalizeLogin(ev.BroadcasterUserLogin)
+		if c.watchlist.Get().RoleForLogin(login) == "" {
+			return
+		}
+
+		now := time.Now()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		fn(c.stateFor(login), ev, now)
+	})
+}
+
+func (c *channelCoreCollector) onChannelUpdate(st *channelCoreState, ev channelCoreEvent, now time.Time) {
+	if st.lastTitle != "" && ev.Title != st.lastTitle {
+		st.titleChanges++
+	}
+	if st.lastCategoryID != "" && ev.CategoryID != st.lastCategoryID {
+		st.categoryChanges++
+	}
+	st.lastTitle = ev.Title
+	st.lastCategoryID = ev.CategoryID
+	st.lastCategoryName = ev.CategoryName
+	st.lastEventAt = now
+}
+
+func (c *channelCoreCollector) onStreamOnline(st *channelCoreState, ev channelCoreEvent, now time.Time) {
+	switch {
+	case !st.live:
+		st.streamStarts++
+		st.lastTransitionAt = now
+	case st.streamID != "" && ev.ID != "" && ev.ID != st.streamID:
+		// the stream.offline notification was lost
+		st.streamRestarts++
+		st.lastTransitionAt = now
+	}
+	st.live = true
+	st.streamID = ev.ID
+	st.startedAt = ev.StartedAt
+	st.lastEventAt = now
+}
+
+func (c *channelCoreCollector) onStreamOffline(st *channelCoreState, ev channelCoreEvent, now time.Time) {
+	if st.live {
+		st.streamEnds++
+		st.lastTransitionAt = now
+	}
+	st.live = false
+	st.streamID = ""
+	st.startedAt = time.Time{}
+	st.sample = viewerSample{}
+	st.lastEventAt = now
+	if c.sessions != nil {
+		c.sessions.End(normalizeLogin(ev.BroadcasterUserLogin), now)
+	}
+}
+
+// stateFor returns the state of login, creating it. Callers hold c.mu.
+func (c *channelCoreCollector) stateFor(login string) *channelCoreState {
+	st, ok := c.state[login]
+	if !ok {
+		st = &channelCoreState{}
+		c.state[login] = st
+	}
+	return st
+}
+
+func (c *channelCoreCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	wl := c.watchlist.Get()
+	logins := wl.AllLogins()
 	if len(logins) == 0 {
 		return ErrNoData
 	}
@@ -112,75 +421,109 @@ func (c *channelCoreCollector) Update(ch chan<- prometheus.Metric) error {
 	now := time.Now()
 
 	// GetStreams only returns live streams, and limits to 100 user_logins per request.
-	streamsByLogin := map[string]helix.Stream{}
-	for _, batch := range chunkStrings(logins, 100) {
-		resp, err := c.client.GetStreams(&helix.StreamsParams{UserLogins: batch, First: len(batch)})
-		if err != nil {
-			return err
-		}
-		for _, s := range resp.Data.Streams {
-			streamsByLogin[normalizeLogin(s.UserLogin)] = s
-		}
+	streamsByLogin, err := getStreamsBatched(ctx, c.client, logins)
+	if err != nil {
+		return err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for _, login := range logins {
 		login = normalizeLogin(login)
-		role := c.watchlist.RoleLabelForLogin(login)
+		role := wl.RoleLabelForLogin(login)
 		if role == "" {
 			role = string(RoleWatch)
 		}
 
-		st, ok := c.state[login]
-		if !ok {
-			st = &channelCoreState{}
-			c.state[login] = st
-		}
+		st := c.stateFor(login)
 
-		s, isLive := streamsByLogin[login]
-		viewers := 0.0
-		startedAt := 0.0
-		uptime := 0.0
-		categoryID := 0.0
+		s, polledLive := streamsByLogin[login]
 
-		if isLive {
-			viewers = float64(s.ViewerCount)
-			if !s.StartedAt.IsZero() {
-				startedAt = float64(s.StartedAt.Unix())
-				uptime = now.Sub(s.StartedAt).Seconds()
+		// State machine: the poller is the source of truth. Notifications
+		// update the state as they arrive; Helix may lag behind them, so the
+		// poller reconciles the state only eventGrace after the last one,
+		// counting what the notifications missed. The last title and
+		// category are kept across streams, so changes made while offline
+		// are counted once the channel is live again.
+		if now.Sub(st.lastEventAt) >= eventGrace {
+			switch {
+			case !st.live && polledLive:
+				st.streamStarts++
+				st.lastTransitionAt = now
+			case st.live && polledLive && st.streamID != "" && s.ID != st.streamID:
+				// ended and started again between two polls
+				st.streamRestarts++
+				st.lastTransitionAt = now
+			case st.live && !polledLive:
+				st.streamEnds++
+				st.lastTransitionAt = now
+				st.streamID = ""
+				st.startedAt = time.Time{}
+				st.sample = viewerSample{}
+				if c.sessions != nil {
+					c.sessions.End(login, now)
+				}
 			}
-			if s.GameID != "" {
-				if v, err := strconv.ParseFloat(s.GameID, 64); err == nil {
-					categoryID = v
+			if polledLive {
+				st.streamID = s.ID
+				st.startedAt = s.StartedAt
+				if st.lastTitle != "" && s.Title != "" && s.Title != st.lastTitle {
+					st.titleChanges++
 				}
+				if st.lastCategoryID != "" && s.GameID != "" && s.GameID != st.lastCategoryID {
+					st.categoryChanges++
+				}
+				st.lastTitle = s.Title
+				st.lastCategoryID = s.GameID
+				st.lastCategoryName = s.GameName
 			}
+			st.live = polledLive
 		}
+		isLive := st.live
 
-		// State machine: poller is the source of truth.
-		if !st.live && isLive {
-			st.streamStarts++
-			st.lastTransitionAt = now
-			st.startedAt = s.StartedAt
-		} else if st.live && !isLive {
-			st.streamEnds++
-			st.lastTransitionAt = now
-			st.startedAt = time.Time{}
-			st.lastTitle = ""
-			st.lastCategoryID = ""
+		// Viewer samples only count when they agree with the state, so a
+		// stale poll right after a notification cannot reopen a finished
+		// stream.
+		if isLive && polledLive && s.ID == st.streamID {
+			st.integrate(viewerSample{
+				streamID:     s.ID,
+				viewers:      s.ViewerCount,
+				categoryID:   s.GameID,
+				categoryName: s.GameName,
+				at:           now,
+			}, time.Duration(c.maxGap.Load()))
+			if c.sessions != nil {
+				c.sessions.Observe(login, sessions.Sample{
+					StreamID:     s.ID,
+					StartedAt:    s.StartedAt,
+					Viewers:      s.ViewerCount,
+					CategoryID:   s.GameID,
+					CategoryName: s.GameName,
+					At:           now,
+				})
+			}
 		}
 
+		viewers := 0.0
+		startedAt := 0.0
+		uptime := 0.0
+		categoryID := 0.0
+
 		if isLive {
-			if st.lastTitle != "" && s.Title != "" && s.Title != st.lastTitle {
-				st.titleChanges++
+			if polledLive {
+				viewers = float64(s.ViewerCount)
 			}
-			if st.lastCategoryID != "" && s.GameID != "" && s.GameID != st.lastCategoryID {
-				st.categoryChanges++
+			if !st.startedAt.IsZero() {
+				startedAt = float64(st.startedAt.Unix())
+				uptime = now.Sub(st.startedAt).Seconds()
+			}
+			if st.lastCategoryID != "" {
+				if v, err := strconv.ParseFloat(st.lastCategoryID, 64); err == nil {
+					categoryID = v
+				}
 			}
-			st.lastTitle = s.Title
-			st.lastCategoryID = s.GameID
 		}
 
-		st.live = isLive
-
 		ch <- c.channelLive.mustNewConstMetric(boolToFloat(isLive), login, role)
 		ch <- c.channelViewers.mustNewConstMetric(viewers, login, role)
 		ch <- c.channelStreamStartedAt.mustNewConstMetric(startedAt, login, role)
@@ -190,6 +533,38 @@ func (c *channelCoreCollector) Update(ch chan<- prometheus.Metric) error {
 		ch <- c.channelCategoryChangeTotal.mustNewConstMetric(st.categoryChanges, login, role)
 		ch <- c.channelStreamStartsTotal.mustNewConstMetric(st.streamStarts, login, role)
 		ch <- c.channelStreamEndsTotal.mustNewConstMetric(st.streamEnds, login, role)
+		ch <- c.channelStreamRestartsTotal.mustNewConstMetric(st.streamRestarts, login, role)
+		ch <- c.channelWatchSecondsTotal.mustNewConstMetric(st.watchSeconds, login, role)
+		if isLive {
+			group := CategoryGroupFor(st.lastCategoryID, st.lastCategoryName)
+			ch <- c.channelCategoryGroupInfo.mustNewConstMetric(1, login, role, group)
+		}
+		foldCategoryGroups(st.groupSeconds)
+		for group, secs := range st.groupSeconds {
+			ch <- c.channelCategoryGroupSecondsTotal.mustNewConstMetric(secs, login, role, group)
+		}
+
+		if c.sessions == nil {
+			continue
+		}
+		if last, ok := c.sessions.Last(login); ok {
+			ch <- c.lastStreamPeakViewers.mustNewConstMetric(float64(last.PeakViewers), login, role)
+			ch <- c.lastStreamAverageViewers.mustNewConstMetric(last.AverageViewers, login, role)
+			ch <- c.lastStreamDuration.mustNewConstMetric(last.DurationSeconds, login, role)
+			ch <- c.lastStreamStartedAt.mustNewConstMetric(float64(last.StartedAt.Unix()), login, role)
+			ch <- c.lastStreamEndedAt.mustNewConstMetric(float64(last.EndedAt.Unix()), login, role)
+			ch <- c.lastStreamCategories.mustNewConstMetric(float64(len(last.Categories)), login, role)
+		}
+	}
+
+	// Drop state of channels removed from the watchlist so a re-added channel starts clean.
+	for login := range c.state {
+		if wl.RoleForLogin(login) == "" {
+			delete(c.state, login)
+			if c.sessions != nil {
+				c.sessions.Discard(login)
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeStreams serves GET /streams with the stream set by the test, or none.
+type fakeStreams struct {
+	mu     sync.Mutex
+	stream *helix.Stream
+}
+
+func (f *fakeStreams) set(s *helix.Stream) {
+	f.mu.Lock()
+	f.stream = s
+	f.mu.Unlock()
+}
+
+func newChannelCoreForTest(t *testing.T) (*channelCoreCollector, *fakeStreams) {
+	t.Helper()
+	f := &fakeStreams{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		streams := []helix.Stream{}
+		if f.stream != nil {
+			streams = append(streams, *f.stream)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": streams})
+	}))
+	t.Cleanup(srv.Close)
+	client, err := helix.NewClient(&helix.Options{ClientID: "client", AppAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl, err := NewChannelWatchlist("me", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChannelCoreCollector(slog.New(slog.NewTextHandler(io.Discard, nil)), client, nil, NewSharedWatchlist(wl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*channelCoreCollector), f
+}
+
+func (c *channelCoreCollector) pollForTest(t *testing.T) {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	err := c.Update(context.Background(), ch)
+	close(ch)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// coreStep is a notification received ago before the next poll, or a poll
+// returning live (nil when offline) when event is empty.
+type coreStep struct {
+	event string
+	ago   time.Duration
+	ev    channelCoreEvent
+	live  *helix.Stream
+}
+
+func online(id string, ago time.Duration) coreStep {
+	return coreStep{event: "stream.online", ago: ago, ev: channelCoreEvent{BroadcasterUserLogin: "me", ID: id}}
+}
+
+func offline(ago time.Duration) coreStep {
+	return coreStep{event: "stream.offline", ago: ago, ev: channelCoreEvent{BroadcasterUserLogin: "me"}}
+}
+
+func update(title string, ago time.Duration) coreStep {
+	return coreStep{event: "channel.update", ago: ago, ev: channelCoreEvent{BroadcasterUserLogin: "me", Title: title, CategoryID: "1"}}
+}
+
+func poll(live *helix.Stream) coreStep {
+	return coreStep{live: live}
+}
+
+func liveStream(id, title string) *helix.Stream {
+	return &helix.Stream{ID: id, UserLogin: "me", Title: title, GameID: "1", ViewerCount: 10, StartedAt: time.Now().Add(-time.Hour)}
+}
+
+// wantCore is the state checked after the steps ran.
+type wantCore struct {
+	live                          bool
+	starts, ends, restarts, title float64
+}
+
+func runCoreSteps(t *testing.T, steps []coreStep, want wantCore) {
+	t.Helper()
+	c, streams := newChannelCoreForTest(t)
+	for _, s := range steps {
+		if s.event == "" {
+			streams.set(s.live)
+			c.pollForTest(t)
+			continue
+		}
+		st := c.stateFor("me")
+		at := time.Now().Add(-s.ago)
+		switch s.event {
+		case "stream.online":
+			c.onStreamOnline(st, s.ev, at)
+		case "stream.offline":
+			c.onStreamOffline(st, s.ev, at)
+		case "channel.update":
+			c.onChannelUpdate(st, s.ev, at)
+		}
+	}
+	st := c.stateFor("me")
+	got := wantCore{live: st.live, starts: st.streamStarts, ends: st.streamEnds, restarts: st.streamRestarts, title: st.titleChanges}
+	if got != want {
+		t.Errorf("state = %+v, want %+v", got, want)
+	}
+}
+
+func TestChannelCoreEventGrace(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []coreStep
+		want  wantCore
+	}{
+		{
+			name:  "poll alone counts a start",
+			steps: []coreStep{poll(liveStream("s1", "a"))},
+			want:  wantCore{live: true, starts: 1},
+		},
+		{
+			name:  "notified start is not counted again by the poll",
+			steps: []coreStep{online("s1", 0), poll(liveStream("s1", "a"))},
+			want:  wantCore{live: true, starts: 1},
+		},
+		{
+			name:  "stale poll right after stream.offline does not reopen the stream",
+			steps: []coreStep{online("s1", 10*time.Minute), offline(0), poll(liveStream("s1", "a"))},
+			want:  wantCore{starts: 1, ends: 1},
+		},
+		{
+			name:  "poll counts a missed stream.offline after the grace",
+			steps: []coreStep{online("s1", 5*time.Minute), poll(nil)},
+			want:  wantCore{starts: 1, ends: 1},
+		},
+		{
+			name:  "every title edit is counted",
+			steps: []coreStep{update("a", 10*time.Minute), update("b", 5*time.Minute), update("c", 0), poll(liveStream("s1", "c"))},
+			want:  wantCore{title: 2},
+		},
+		{
+			name:  "title changed while offline",
+			steps: []coreStep{update("a", 10*time.Minute), update("b", 5*time.Minute), poll(nil)},
+			want:  wantCore{title: 1},
+		},
+		{
+			name:  "poll catches a missed channel.update after the grace",
+			steps: []coreStep{update("a", 5*time.Minute), poll(liveStream("s1", "b"))},
+			want:  wantCore{live: true, starts: 1, title: 1},
+		},
+		{
+			name:  "stale title within the grace is left alone",
+			steps: []coreStep{update("a", 10*time.Minute), update("b", 0), poll(liveStream("s1", "a"))},
+			want:  wantCore{title: 1},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runCoreSteps(t, tc.steps, tc.want)
+		})
+	}
+}
+
+func TestChannelCoreStreamRestarts(t *testing.T) {
+	cases := []struct {
+		name  string
+		steps []coreStep
+		want  wantCore
+	}{
+		{
+			name:  "same stream polled twice",
+			steps: []coreStep{poll(liveStream("s1", "a")), poll(liveStream("s1", "a"))},
+			want:  wantCore{live: true, starts: 1},
+		},
+		{
+			name:  "new stream ID between two polls",
+			steps: []coreStep{poll(liveStream("s1", "a")), poll(liveStream("s2", "a"))},
+			want:  wantCore{live: true, starts: 1, restarts: 1},
+		},
+		{
+			name:  "offline poll in between",
+			steps: []coreStep{poll(liveStream("s1", "a")), poll(nil), poll(liveStream("s2", "a"))},
+			want:  wantCore{live: true, starts: 2, ends: 1},
+		},
+		{
+			name:  "stream.online with a new ID after a lost stream.offline",
+			steps: []coreStep{online("s1", 10*time.Minute), online("s2", 0)},
+			want:  wantCore{live: true, starts: 1, restarts: 1},
+		},
+		{
+			name:  "redelivered stream.online",
+			steps: []coreStep{online("s1", 10*time.Minute), online("s1", 0)},
+			want:  wantCore{live: true, starts: 1},
+		},
+		{
+			name:  "stream.offline then stream.online",
+			steps: []coreStep{online("s1", 10*time.Minute), offline(5 * time.Minute), online("s2", 0)},
+			want:  wantCore{live: true, starts: 2, ends: 1},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runCoreSteps(t, tc.steps, tc.want)
+		})
+	}
+}
+
+func TestChannelCoreRestartMovesStartedAt(t *testing.T) {
+	c, streams := newChannelCoreForTest(t)
+	first, second := liveStream("s1", "a"), liveStream("s2", "a")
+	second.StartedAt = time.Now().Add(-time.Minute)
+
+	streams.set(first)
+	c.pollForTest(t)
+	streams.set(second)
+	c.pollForTest(t)
+
+	st := c.stateFor("me")
+	if st.streamID != "s2" || !st.startedAt.Equal(second.StartedAt) {
+		t.Errorf("session = %s started %s, want s2 started %s", st.streamID, st.startedAt, second.StartedAt)
+	}
+	// the viewer integral starts over with the new stream
+	if st.watchSeconds != 0 {
+		t.Errorf("watch seconds = %v across a restart, want 0", st.watchSeconds)
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	t0 := time.Unix(1_700_000_000, 0)
+	sample := func(streamID string, viewers int, after time.Duration) viewerSample {
+		return viewerSample{streamID: streamID, viewers: viewers, at: t0.Add(after)}
+	}
+	cases := []struct {
+		name        string
+		samples     []viewerSample
+		wantWatch   float64
+		wantSeconds float64
+	}{
+		{name: "first sample", samples: []viewerSample{sample("s1", 10, 0)}},
+		{
+			name:        "same stream",
+			samples:     []viewerSample{sample("s1", 10, 0), sample("s1", 20, time.Minute)},
+			wantWatch:   15 * 60,
+			wantSeconds: 60,
+		},
+		{
+			name:        "jittered polls",
+			samples:     []viewerSample{sample("s1", 10, 0), sample("s1", 10, 50*time.Second), sample("s1", 10, 2*time.Minute)},
+			wantWatch:   10 * 120,
+			wantSeconds: 120,
+		},
+		{
+			name:        "restart starts over",
+			samples:     []viewerSample{sample("s1", 10, 0), sample("s2", 10, time.Minute), sample("s2", 10, 2*time.Minute)},
+			wantWatch:   10 * 60,
+			wantSeconds: 60,
+		},
+		{name: "same time", samples: []viewerSample{sample("s1", 10, 0), sample("s1", 10, 0)}},
+		{name: "clock went back", samples: []viewerSample{sample("s1", 10, time.Minute), sample("s1", 10, 0)}},
+		{
+			name:        "long gap is not credited",
+			samples:     []viewerSample{sample("s1", 10, 0), sample("s1", 10, 3*time.Minute), sample("s1", 10, 4*time.Minute)},
+			wantWatch:   10 * 60,
+			wantSeconds: 60,
+		},
+		{
+			name:        "gap at the limit is credited",
+			samples:     []viewerSample{sample("s1", 10, 0), sample("s1", 10, 2*time.Minute)},
+			wantWatch:   10 * 120,
+			wantSeconds: 120,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var st channelCoreState
+			for _, s := range tc.samples {
+				st.integrate(s, 2*time.Minute)
+			}
+			seconds := 0.0
+			for _, v := range st.groupSeconds {
+				seconds += v
+			}
+			if st.watchSeconds != tc.wantWatch || seconds != tc.wantSeconds {
+				t.Errorf("watch seconds = %v, group seconds = %v; want %v, %v", st.watchSeconds, seconds, tc.wantWatch, tc.wantSeconds)
+			}
+		})
+	}
+}
+
+func TestChannelCoreSetInterval(t *testing.T) {
+	c, _ := newChannelCoreForTest(t)
+	cases := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{0, maxScrapeSampleGap},
+		{time.Minute, 2 * time.Minute},
+		{10 * time.Minute, 20 * time.Minute},
+	}
+	for _, tc := range cases {
+		c.setInterval(tc.interval)
+		if got := time.Duration(c.maxGap.Load()); got != tc.want {
+			t.Errorf("interval %s: max gap = %s, want %s", tc.interval, got, tc.want)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package collector
 
 import (
-	"errors"
+	"context"
 	"log/slog"
 
 	"github.com/nicklaw5/helix/v2"
@@ -12,7 +12,7 @@ import (
 type channelFollowersTotalCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
 
 	channelFollowers typedDesc
 }
@@ -21,7 +21,7 @@ func init() {
 	registerCollector("channel_followers_total", defaultEnabled, NewChannelFollowersTotalCollector)
 }
 
-func NewChannelFollowersTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelFollowersTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	c := channelFollowersTotalCollector{
 		logger:    logger,
 		client:    client,
@@ -37,29 +37,21 @@ func NewChannelFollowersTotalCollector(logger *slog.Logger, client *helix.Client
 	return c, nil
 }
 
-func (c channelFollowersTotalCollector) Update(ch chan<- prometheus.Metric) error {
-	logins := c.watchlist.AllLogins()
+func (c channelFollowersTotalCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	logins := c.watchlist.Get().AllLogins()
 	if len(logins) == 0 {
 		return ErrNoData
 	}
 
-	usersResp, err := c.client.GetUsers(&helix.UsersParams{
-		Logins: logins,
-	})
-
+	users, err := resolveUsers(ctx, c.client, logins)
 	if err != nil {
 		c.logger.Error("Failed to collect users stats from Twitch helix API", "err", err)
 		return err
 	}
 
-	if usersResp.StatusCode != 200 {
-		c.logger.Error("Failed to collect users stats from Twitch helix API", "err", usersResp.ErrorMessage)
-		return errors.New(usersResp.ErrorMessage)
-	}
-
-	// todo: we can avoid this with a shared cache of username to userID that has a short TTL
-	for _, user := range usersResp.Data.Users {
-		usersFollowsResp, err := c.client.GetChannelFollows(&helix.GetChannelFollowsParams{
+	client := withContext(ctx, c.client)
+	return forEachConcurrent(ctx, users, func(user helix.User) error {
+		usersFollowsResp, err := client.GetChannelFollows(&helix.GetChannelFollowsParams{
 			BroadcasterID: user.ID,
 		})
 
@@ -68,13 +60,12 @@ func (c channelFollowersTotalCollector) Update(ch chan<- prometheus.Metric) erro
 			return err
 		}
 
-		if usersFollowsResp.StatusCode != 200 {
-			c.logger.Error("Failed to collect follower stats from Twitch helix API", "err", usersFollowsResp.ErrorMessage)
-			return errors.New(usersFollowsResp.ErrorMessage)
+		if err := checkResponse("channels/followers", usersFollowsResp.ResponseCommon); err != nil {
+			c.logger.Error("Failed to collect follower stats from Twitch helix API", "err", err)
+			return err
 		}
 
 		ch <- c.channelFollowers.mustNewConstMetric(float64(usersFollowsResp.Data.Total), user.DisplayName)
-	}
-
-	return nil
+		return nil
+	})
 }
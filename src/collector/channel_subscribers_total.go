@@ -1,7 +1,7 @@
 package collector
 
 import (
-	"errors"
+	"context"
 	"log/slog"
 
 	"github.com/nicklaw5/helix/v2"
@@ -17,7 +17,7 @@ const (
 type ChannelSubscriberTotalCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
 
 	channelSubscribersTotal typedDesc
 }
@@ -26,7 +26,7 @@ func init() {
 	registerCollector("channel_subscribers_total", defaultDisabled, NewChannelSubscriberTotalCollector)
 }
 
-func NewChannelSubscriberTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelSubscriberTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	c := ChannelSubscriberTotalCollector{
 		logger:    logger,
 		client:    client,
@@ -42,29 +42,21 @@ func NewChannelSubscriberTotalCollector(logger *slog.Logger, client *helix.Clien
 	return c, nil
 }
 
-func (c ChannelSubscriberTotalCollector) Update(ch chan<- prometheus.Metric) error {
-	logins := c.watchlist.AllLogins()
+func (c ChannelSubscriberTotalCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	logins := c.watchlist.Get().AllLogins()
 	if len(logins) == 0 {
 		return ErrNoData
 	}
 
-	usersResp, err := c.client.GetUsers(&helix.UsersParams{
-		Logins: logins,
-	})
-
+	users, err := resolveUsers(ctx, c.client, logins)
 	if err != nil {
 		c.logger.Error("Failed to collect users stats from Twitch helix API", "err", err)
 		return err
 	}
 
-	if usersResp.StatusCode != 200 {
-		c.logger.Error("Failed to collect users stats from Twitch helix API", "err", usersResp.ErrorMessage)
-		return errors.New(usersResp.ErrorMessage)
-	}
-
-	// todo: we can avoid this with a shared cache of username to userID that has a short TTL
-	for _, user := range usersResp.Data.Users {
-		subscribtionsResp, err := c.client.GetSubscriptions(&helix.SubscriptionsParams{
+	client := withContext(ctx, c.client)
+	return forEachConcurrent(ctx, users, func(user helix.User) error {
+		subscribtionsResp, err := client.GetSubscriptions(&helix.SubscriptionsParams{
 			BroadcasterID: user.ID,
 		})
 
@@ -73,9 +65,9 @@ func (c ChannelSubscriberTotalCollector) Update(ch chan<- prometheus.Metric) err
 			return err
 		}
 
-		if subscribtionsResp.StatusCode != 200 {
-			c.logger.Error("Failed to collect subscribers stats from Twitch helix API", "err", subscribtionsResp.ErrorMessage)
-			return errors.New(subscribtionsResp.ErrorMessage)
+		if err := checkResponse("subscriptions", subscribtionsResp.ResponseCommon); err != nil {
+			c.logger.Error("Failed to collect subscribers stats from Twitch helix API", "err", err)
+			return err
 		}
 
 		subCounter := make(map[string]int)
@@ -102,7 +94,6 @@ func (c ChannelSubscriberTotalCollector) Update(ch chan<- prometheus.Metric) err
 		for tier, counter := range subCounter {
 			ch <- c.channelSubscribersTotal.mustNewConstMetric(float64(counter), user.DisplayName, tier, notGiftedSub)
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/nicklaw5/helix/v2"
@@ -11,7 +12,7 @@ import (
 type channelUpCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
 
 	channelUp typedDesc
 }
@@ -21,7 +22,7 @@ func init() {
 	registerCollector("channel_up", defaultDisabled, NewChannelUpCollector)
 }
 
-func NewChannelUpCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelUpCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	c := channelUpCollector{
 		logger:    logger,
 		client:    client,
@@ -37,17 +38,13 @@ func NewChannelUpCollector(logger *slog.Logger, client *helix.Client, eventsubCl
 	return c, nil
 }
 
-func (c channelUpCollector) Update(ch chan<- prometheus.Metric) error {
-	logins := c.watchlist.AllLogins()
+func (c channelUpCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	logins := c.watchlist.Get().AllLogins()
 	if len(logins) == 0 {
 		return ErrNoData
 	}
 
-	streamsResp, err := c.client.GetStreams(&helix.StreamsParams{
-		UserLogins: logins,
-		First:      len(logins),
-	})
-
+	streams, err := getStreamsBatched(ctx, c.client, logins)
 	if err != nil {
 		c.logger.Error("could not get streams", "err", err)
 		return err
@@ -57,12 +54,9 @@ func (c channelUpCollector) Update(ch chan<- prometheus.Metric) error {
 		state := 0
 		game := ""
 
-		for _, s := range streamsResp.Data.Streams {
-			if s.UserName == n {
-				state = 1
-				game = s.GameName
-				break
-			}
+		if s, ok := streams[n]; ok {
+			state = 1
+			game = s.GameName
 		}
 
 		ch <- c.channelUp.mustNewConstMetric(float64(state), n, game)
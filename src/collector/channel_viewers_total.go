@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/nicklaw5/helix/v2"
@@ -11,7 +12,7 @@ import (
 type ChannelViewersTotalCollector struct {
 	logger    *slog.Logger
 	client    *helix.Client
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
 
 	channelViewersTotal typedDesc
 }
@@ -21,7 +22,7 @@ func init() {
 	registerCollector("channel_viewers_total", defaultDisabled, NewChannelViewersTotalCollector)
 }
 
-func NewChannelViewersTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewChannelViewersTotalCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	c := ChannelViewersTotalCollector{
 		logger:    logger,
 		client:    client,
@@ -37,23 +38,19 @@ func NewChannelViewersTotalCollector(logger *slog.Logger, client *helix.Client,
 	return c, nil
 }
 
-func (c ChannelViewersTotalCollector) Update(ch chan<- prometheus.Metric) error {
-	logins := c.watchlist.AllLogins()
+func (c ChannelViewersTotalCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	logins := c.watchlist.Get().AllLogins()
 	if len(logins) == 0 {
 		return ErrNoData
 	}
 
-	streamsResp, err := c.client.GetStreams(&helix.StreamsParams{
-		UserLogins: logins,
-		First:      len(logins),
-	})
-
+	streams, err := getStreamsBatched(ctx, c.client, logins)
 	if err != nil {
 		c.logger.Error("could not get streams", "err", err)
 		return err
 	}
 
-	for _, s := range streamsResp.Data.Streams {
+	for _, s := range streams {
 		ch <- c.channelViewersTotal.mustNewConstMetric(float64(s.ViewerCount), s.UserName, s.GameName)
 	}
 
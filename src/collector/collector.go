@@ -4,6 +4,7 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -39,14 +40,19 @@ const (
 )
 
 var (
-	factories              = make(map[string]func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error))
+	factories              = make(map[string]func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error))
 	initiatedCollectorsMtx = sync.Mutex{}
 	initiatedCollectors    = make(map[string]Collector)
 	collectorState         = make(map[string]*bool)
 	forcedCollectors       = map[string]bool{} // collectors which have been explicitly enabled or disabled
+
+	// collectorOverrides holds per-collector enablement from the config file.
+	// Explicit command line flags always win over these.
+	collectorOverrides      = map[string]bool{}
+	defaultCollectorsForced bool // set once DisableDefaultCollectors has run
 )
 
-func registerCollector(collector string, isDefaultEnabled bool, factory func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error)) {
+func registerCollector(collector string, isDefaultEnabled bool, factory func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error)) {
 	var helpDefaultState string
 	if isDefaultEnabled {
 		helpDefaultState = "enabled"
@@ -66,8 +72,30 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func(log
 
 type Exporter struct {
 	Collectors map[string]Collector
-	client     *helix.Client
-	logger     *slog.Logger
+
+	mu             sync.RWMutex
+	client         *helix.Client
+	eventsubClient *eventsub.Client
+	watchlist      *SharedWatchlist
+	filters        map[string]bool
+	logger         *slog.Logger
+	probe          bool // serving /probe, see probe.go
+
+	// background polling, see scheduler.go
+	defaultInterval time.Duration
+	intervals       map[string]time.Duration
+	jobs            map[string]*scheduledJob
+
+	// per-collector deadlines, see timeout.go
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration
+}
+
+// CollectorSettings holds the per-collector settings from the config file.
+type CollectorSettings struct {
+	Enabled   map[string]bool
+	Intervals map[string]time.Duration
+	Timeouts  map[string]time.Duration
 }
 
 // Describe describes all the metrics ever exported by the Twitch exporter. It
@@ -75,12 +103,19 @@ type Exporter struct {
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- cacheAgeDesc
+	if e.probe {
+		return
+	}
 	for _, c := range runtimeCollectors() {
 		c.Describe(ch)
 	}
 }
 
 func DisableDefaultCollectors() {
+	initiatedCollectorsMtx.Lock()
+	defaultCollectorsForced = true
+	initiatedCollectorsMtx.Unlock()
 	for c := range collectorState {
 		if _, ok := forcedCollectors[c]; !ok {
 			*collectorState[c] = false
@@ -121,7 +156,43 @@ func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error
 	}
 }
 
-func NewExporter(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist, filters ...string) (*Exporter, error) {
+// SetCollectorOverrides sets per-collector enablement loaded from the config
+// file. Collectors explicitly toggled on the command line are not affected.
+func SetCollectorOverrides(overrides map[string]bool) error {
+	if err := validateCollectorNames(overrides); err != nil {
+		return err
+	}
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+	collectorOverrides = copyBoolMap(overrides)
+	return nil
+}
+
+func validateCollectorNames[V any](settings map[string]V) error {
+	for name := range settings {
+		if _, ok := collectorState[name]; !ok {
+			return fmt.Errorf("unknown collector: %s", name)
+		}
+	}
+	return nil
+}
+
+// collectorEnabled resolves whether a collector should run. Precedence is:
+// command line flag, config file override, registered default. Once default
+// collectors have been disabled (e.g. missing credentials) overrides are
+// ignored and only explicit flags can enable a collector.
+func collectorEnabled(name string, overrides map[string]bool) bool {
+	enabled := *collectorState[name]
+	if forcedCollectors[name] || defaultCollectorsForced {
+		return enabled
+	}
+	if v, ok := overrides[name]; ok {
+		return v
+	}
+	return enabled
+}
+
+func NewExporter(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist, filters ...string) (*Exporter, error) {
 	f := make(map[string]bool)
 	for _, filter := range filters {
 		enabled, exist := collectorState[filter]
@@ -135,61 +206,153 @@ func NewExporter(logger *slog.Logger, client *helix.Client, eventsubClient *even
 		f[filter] = true
 	}
 
-	collectors := make(map[string]Collector)
+	e := &Exporter{
+		client:         client,
+		eventsubClient: eventsubClient,
+		watchlist:      watchlist,
+		filters:        f,
+		logger:         logger,
+	}
+
 	initiatedCollectorsMtx.Lock()
 	defer initiatedCollectorsMtx.Unlock()
-	for key, enabled := range collectorState {
-		if !*enabled || (len(f) > 0 && !f[key]) {
+	collectors, err := e.buildCollectors(collectorOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range collectors {
+		logger.Info("enabled collector", "collector", k)
+	}
+
+	e.Collectors = collectors
+	return e, nil
+}
+
+// buildCollectors returns the enabled collectors, reusing already initiated
+// instances so their in-memory state survives. initiatedCollectorsMtx must be held.
+func (e *Exporter) buildCollectors(overrides map[string]bool) (map[string]Collector, error) {
+	collectors := make(map[string]Collector)
+	created := make(map[string]Collector)
+	for key := range collectorState {
+		if !collectorEnabled(key, overrides) || (len(e.filters) > 0 && !e.filters[key]) {
 			continue
 		}
 		if collector, ok := initiatedCollectors[key]; ok {
 			collectors[key] = collector
-		} else {
-			collector, err := factories[key](logger, client, eventsubClient, watchlist)
-			if err != nil {
-				return nil, err
-			}
-			collectors[key] = collector
-			initiatedCollectors[key] = collector
+			continue
 		}
+		collector, err := factories[key](e.logger, e.client, e.eventsubClient, e.watchlist)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", key, err)
+		}
+		collectors[key] = collector
+		created[key] = collector
 	}
-
-	for k := range collectors {
-		logger.Info("enabled collector", "collector", k)
+	for key, collector := range created {
+		initiatedCollectors[key] = collector
 	}
+	return collectors, nil
+}
+
+// Watchlist returns the shared watchlist read by the exporter's collectors.
+func (e *Exporter) Watchlist() *SharedWatchlist {
+	return e.watchlist
+}
 
-	return &Exporter{
-		Collectors: collectors,
+// Reload swaps in a new watchlist and config file collector settings.
+// Running collectors keep their state; newly enabled collectors are created
+// against the shared watchlist. Nothing changes if a collector fails to build.
+func (e *Exporter) Reload(watchlist ChannelWatchlist, settings CollectorSettings) error {
+	overrides := settings.Enabled
+	if err := validateCollectorNames(overrides); err != nil {
+		return err
+	}
+	if err := validateIntervals(0, settings.Intervals); err != nil {
+		return err
+	}
+	if err := validateTimeouts(0, settings.Timeouts); err != nil {
+		return err
+	}
 
-		client: client,
-		logger: logger,
-	}, nil
+	// the collectors are built against the new watchlist while changes from
+	// the admin API wait, so no change is lost or notified out of order
+	return e.watchlist.Set(watchlist, func() error {
+		initiatedCollectorsMtx.Lock()
+		collectors, err := e.buildCollectors(overrides)
+		if err != nil {
+			initiatedCollectorsMtx.Unlock()
+			return err
+		}
+		collectorOverrides = copyBoolMap(overrides)
+		initiatedCollectorsMtx.Unlock()
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for name := range collectors {
+			if _, ok := e.Collectors[name]; !ok {
+				e.logger.Info("enabled collector", "collector", name)
+			}
+		}
+		for name := range e.Collectors {
+			if _, ok := collectors[name]; !ok {
+				e.logger.Info("disabled collector", "collector", name)
+			}
+		}
+		e.Collectors = collectors
+		e.intervals = copyDurationMap(settings.Intervals)
+		e.timeouts = copyDurationMap(settings.Timeouts)
+		e.syncJobs()
+		return nil
+	})
 }
 
+// Collect runs the collectors without a scrape deadline; only per-collector
+// timeouts apply. Use WithContext to bound a scrape.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	for _, c := range runtimeCollectors() {
-		c.Collect(ch)
+	e.collect(context.Background(), ch)
+}
+
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	if !e.probe {
+		for _, c := range runtimeCollectors() {
+			c.Collect(ch)
+		}
 	}
 
+	e.mu.RLock()
+	collectors := e.Collectors
+	jobs := e.jobs
+	e.mu.RUnlock()
+
 	wg := sync.WaitGroup{}
-	wg.Add(len(e.Collectors))
-	for name, c := range e.Collectors {
+	for name, c := range collectors {
+		if job, ok := jobs[name]; ok {
+			job.collect(ch)
+			continue
+		}
+		wg.Add(1)
 		go func(name string, c Collector) {
-			execute(name, c, ch, e.logger)
+			ctx, cancel := e.collectorContext(ctx, name)
+			defer cancel()
+			execute(ctx, name, c, ch, e.logger)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, logger *slog.Logger) {
+func execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric, logger *slog.Logger) {
 	begin := time.Now()
-	err := c.Update(ch)
+	metrics, err := runUpdate(ctx, c)
 	duration := time.Since(begin)
+	for _, m := range metrics {
+		ch <- m
+	}
 	var success float64
 
 	if err != nil {
-		ObserveCollectorError(name, ClassifyErrorReason(err))
+		ObserveCollectorError(name, errorReason(ctx, err))
 		if IsNoDataError(err) {
 			logger.Error("collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else {
@@ -209,7 +372,7 @@ func execute(name string, c Collector, ch chan<- prometheus.Metric, logger *slog
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
-	Update(ch chan<- prometheus.Metric) error
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
 }
 
 type typedDesc struct {
@@ -226,3 +389,11 @@ var ErrNoData = errors.New("collector returned no data")
 func IsNoDataError(err error) bool {
 	return err == ErrNoData
 }
+
+func copyBoolMap(in map[string]bool) map[string]bool {
+	out := map[string]bool{}
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
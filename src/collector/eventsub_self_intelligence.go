@@ -1,29 +1,40 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
+	"maps"
+	"strings"
 	"sync"
 
 	"github.com/nicklaw5/helix/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webgrip/twitch_exporter/internal/eventsub"
+	"github.com/webgrip/twitch_exporter/internal/statestore"
 )
 
 // EventSub deep metrics for the self channel only.
 // All labels are strictly bounded (channel, role, small enums).
 
 type eventsubSelfCollector struct {
-	logger       *slog.Logger
-	client       *helix.Client
-	eventsub     *eventsub.Client
-	watchlist    ChannelWatchlist
-	selfLogin    string
-	selfUserID   string
-	desiredTypes map[string]bool
+	logger     *slog.Logger
+	client     *helix.Client
+	eventsub   *eventsub.Client
+	watchlist  *SharedWatchlist
+	selfLogin  string
+	selfUserID string
+	// desired maps every subscribed event type to the self channel condition
+	// it was subscribed with.
+	desired map[string]helix.EventSubCondition
 
 	mu sync.Mutex
 	st eventsubSelfState
+	// scalars and labelled map the counter names used in the state store to
+	// the fields of st.
+	scalars  map[string]*float64
+	labelled map[string]map[string]float64
+	store    statestore.Store
 
 	notificationsTotal     typedDesc
 	subDesired             typedDesc
@@ -78,8 +89,8 @@ func init() {
 	registerCollector("eventsub_self", defaultDisabled, NewEventSubSelfCollector)
 }
 
-func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
-	selfLogin := watchlist.SelfLogin()
+func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
+	selfLogin := watchlist.Get().SelfLogin()
 	if selfLogin == "" {
 		IncCollectorDisabled("eventsub_self", "not_self_channel")
 		return noopCollector{}, nil
@@ -93,23 +104,24 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		return noopCollector{}, nil
 	}
 
-	users, err := client.GetUsers(&helix.UsersParams{Logins: []string{selfLogin}})
+	selfUser, found, err := resolveUser(context.Background(), client, selfLogin)
 	if err != nil {
 		return nil, err
 	}
-	if len(users.Data.Users) == 0 {
+	if !found {
 		IncCollectorDisabled("eventsub_self", "not_self_channel")
 		return noopCollector{}, nil
 	}
 
 	c := &eventsubSelfCollector{
-		logger:       logger,
-		client:       client,
-		eventsub:     eventsubClient,
-		watchlist:    watchlist,
-		selfLogin:    selfLogin,
-		selfUserID:   users.Data.Users[0].ID,
-		desiredTypes: map[string]bool{},
+		logger:     logger,
+		client:     client,
+		eventsub:   eventsubClient,
+		watchlist:  watchlist,
+		selfLogin:  selfLogin,
+		selfUserID: selfUser.ID,
+		desired:    map[string]helix.EventSubCondition{},
+		store:      StateStore(),
 		st: eventsubSelfState{
 			notifications: map[string]float64{},
 			subsByKind:    map[string]float64{"new": 0, "resub": 0},
@@ -135,7 +147,7 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		), prometheus.GaugeValue},
 		subActive: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "eventsub", "subscription_active"),
-			"Whether an EventSub subscription is currently active/enabled for this event type (1 = yes, 0 = no).",
+			"Whether the EventSub subscription for this event type on the self channel is currently active/enabled (1 = yes, 0 = no).",
 			[]string{"event_type"}, nil,
 		), prometheus.GaugeValue},
 
@@ -226,15 +238,35 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 	// Ensure at least one sample exists for reward_group series once events arrive.
 	c.st.pointsByGroup[RewardGroupFor("", "")] = 0
 
+	c.scalars = map[string]*float64{
+		"follows":            &c.st.follows,
+		"gift_subscriptions": &c.st.giftSubs,
+		"bits_events":        &c.st.bitsEvents,
+		"bits":               &c.st.bitsTotal,
+		"raids_in":           &c.st.raidsIn,
+		"raids_out":          &c.st.raidsOut,
+		"ad_breaks":          &c.st.adsBreaks,
+		"ad_minutes":         &c.st.adsMinutes,
+	}
+	c.labelled = map[string]map[string]float64{
+		"notifications":      c.st.notifications,
+		"subscriptions":      c.st.subsByKind,
+		"points_redemptions": c.st.pointsByGroup,
+		"hype_train_events":  c.st.hypeTrainByStage,
+		"goals_events":       c.st.goalsByStage,
+		"polls_events":       c.st.pollsByStage,
+		"predictions_events": c.st.predictionsByStage,
+		"charity_events":     c.st.charityByStage,
+		"moderation_actions": c.st.moderationByAction,
+	}
+	c.restore()
+
 	// Always attempt public stream online/offline (app).
-	_ = c.eventsub.SubscribeApp("stream.online", "1", helix.EventSubCondition{BroadcasterUserID: c.selfUserID})
-	c.desire("stream.online")
-	_ = c.eventsub.SubscribeApp("stream.offline", "1", helix.EventSubCondition{BroadcasterUserID: c.selfUserID})
-	c.desire("stream.offline")
+	c.subscribeApp("stream.online", helix.EventSubCondition{BroadcasterUserID: c.selfUserID})
+	c.subscribeApp("stream.offline", helix.EventSubCondition{BroadcasterUserID: c.selfUserID})
 
 	// Raids are public.
-	_ = c.eventsub.SubscribeApp("channel.raid", "1", helix.EventSubCondition{ToBroadcasterUserID: c.selfUserID})
-	c.desire("channel.raid")
+	c.subscribeApp("channel.raid", helix.EventSubCondition{ToBroadcasterUserID: c.selfUserID})
 
 	// Deep/self-only types gated by user token scopes.
 	c.subscribeUserIfScope("channel.follow", "2", helix.EventSubCondition{BroadcasterUserID: c.selfUserID, ModeratorUserID: c.selfUserID}, "moderator:read:followers")
@@ -276,13 +308,23 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 	c.subscribeUserIfScope("channel.ad_break.end", "1", helix.EventSubCondition{BroadcasterUserID: c.selfUserID}, "channel:read:ads")
 
 	// Handlers.
-	c.on("stream.online", func(_ json.RawMessage) { c.incNotification("stream.online") })
-	c.on("stream.offline", func(_ json.RawMessage) { c.incNotification("stream.offline") })
+	// stream.* and channel.raid are also delivered for watched channels
+	// (eventsub_watch), so those are filtered by broadcaster.
+	c.on("stream.online", func(raw json.RawMessage) {
+		if c.isSelf(raw) {
+			c.incNotification("stream.online")
+		}
+	})
+	c.on("stream.offline", func(raw json.RawMessage) {
+		if c.isSelf(raw) {
+			c.incNotification("stream.offline")
+		}
+	})
 
 	c.on("channel.follow", func(_ json.RawMessage) {
 		c.incNotification("channel.follow")
 		c.mu.Lock()
-		c.st.follows++
+		c.count("follows", "", 1)
 		c.mu.Unlock()
 	})
 
@@ -294,9 +336,9 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		_ = json.Unmarshal(raw, &ev)
 		c.mu.Lock()
 		if ev.IsGift {
-			c.st.giftSubs++
+			c.count("gift_subscriptions", "", 1)
 		} else {
-			c.st.subsByKind["new"]++
+			c.count("subscriptions", "new", 1)
 		}
 		c.mu.Unlock()
 	})
@@ -304,14 +346,14 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 	c.on("channel.subscription.message", func(_ json.RawMessage) {
 		c.incNotification("channel.subscription.message")
 		c.mu.Lock()
-		c.st.subsByKind["resub"]++
+		c.count("subscriptions", "resub", 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.subscription.gift", func(_ json.RawMessage) {
 		c.incNotification("channel.subscription.gift")
 		c.mu.Lock()
-		c.st.giftSubs++
+		c.count("gift_subscriptions", "", 1)
 		c.mu.Unlock()
 	})
 
@@ -324,8 +366,8 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 			return
 		}
 		c.mu.Lock()
-		c.st.bitsEvents++
-		c.st.bitsTotal += float64(ev.Bits)
+		c.count("bits_events", "", 1)
+		c.count("bits", "", float64(ev.Bits))
 		c.mu.Unlock()
 	})
 
@@ -342,12 +384,11 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		}
 		group := RewardGroupFor(ev.Reward.ID, ev.Reward.Title)
 		c.mu.Lock()
-		c.st.pointsByGroup[group]++
+		c.count("points_redemptions", group, 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.raid", func(raw json.RawMessage) {
-		c.incNotification("channel.raid")
 		var ev struct {
 			FromBroadcasterUserID string `json:"from_broadcaster_user_id"`
 			ToBroadcasterUserID   string `json:"to_broadcaster_user_id"`
@@ -355,11 +396,15 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		if json.Unmarshal(raw, &ev) != nil {
 			return
 		}
+		if ev.ToBroadcasterUserID != c.selfUserID && ev.FromBroadcasterUserID != c.selfUserID {
+			return
+		}
+		c.incNotification("channel.raid")
 		c.mu.Lock()
 		if ev.ToBroadcasterUserID == c.selfUserID {
-			c.st.raidsIn++
-		} else if ev.FromBroadcasterUserID == c.selfUserID {
-			c.st.raidsOut++
+			c.count("raids_in", "", 1)
+		} else {
+			c.count("raids_out", "", 1)
 		}
 		c.mu.Unlock()
 	})
@@ -371,42 +416,42 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 		}
 		_ = json.Unmarshal(raw, &ev)
 		c.mu.Lock()
-		c.st.adsBreaks++
+		c.count("ad_breaks", "", 1)
 		if ev.DurationSeconds > 0 {
-			c.st.adsMinutes += float64(ev.DurationSeconds) / 60.0
+			c.count("ad_minutes", "", float64(ev.DurationSeconds)/60.0)
 		}
 		c.mu.Unlock()
 	})
 
-	stageCounter := func(eventType string, stageMap *map[string]float64, stage string) func(json.RawMessage) {
+	stageCounter := func(eventType string, counter string, stage string) func(json.RawMessage) {
 		return func(_ json.RawMessage) {
 			c.incNotification(eventType)
 			c.mu.Lock()
-			(*stageMap)[stage]++
+			c.count(counter, stage, 1)
 			c.mu.Unlock()
 		}
 	}
 
-	c.on("channel.hype_train.begin", stageCounter("channel.hype_train.begin", &c.st.hypeTrainByStage, "begin"))
-	c.on("channel.hype_train.progress", stageCounter("channel.hype_train.progress", &c.st.hypeTrainByStage, "progress"))
-	c.on("channel.hype_train.end", stageCounter("channel.hype_train.end", &c.st.hypeTrainByStage, "end"))
+	c.on("channel.hype_train.begin", stageCounter("channel.hype_train.begin", "hype_train_events", "begin"))
+	c.on("channel.hype_train.progress", stageCounter("channel.hype_train.progress", "hype_train_events", "progress"))
+	c.on("channel.hype_train.end", stageCounter("channel.hype_train.end", "hype_train_events", "end"))
 
-	c.on("channel.goal.begin", stageCounter("channel.goal.begin", &c.st.goalsByStage, "begin"))
-	c.on("channel.goal.progress", stageCounter("channel.goal.progress", &c.st.goalsByStage, "progress"))
-	c.on("channel.goal.end", stageCounter("channel.goal.end", &c.st.goalsByStage, "end"))
+	c.on("channel.goal.begin", stageCounter("channel.goal.begin", "goals_events", "begin"))
+	c.on("channel.goal.progress", stageCounter("channel.goal.progress", "goals_events", "progress"))
+	c.on("channel.goal.end", stageCounter("channel.goal.end", "goals_events", "end"))
 
-	c.on("channel.poll.begin", stageCounter("channel.poll.begin", &c.st.pollsByStage, "begin"))
-	c.on("channel.poll.progress", stageCounter("channel.poll.progress", &c.st.pollsByStage, "progress"))
-	c.on("channel.poll.end", stageCounter("channel.poll.end", &c.st.pollsByStage, "end"))
+	c.on("channel.poll.begin", stageCounter("channel.poll.begin", "polls_events", "begin"))
+	c.on("channel.poll.progress", stageCounter("channel.poll.progress", "polls_events", "progress"))
+	c.on("channel.poll.end", stageCounter("channel.poll.end", "polls_events", "end"))
 
-	c.on("channel.prediction.begin", stageCounter("channel.prediction.begin", &c.st.predictionsByStage, "begin"))
-	c.on("channel.prediction.progress", stageCounter("channel.prediction.progress", &c.st.predictionsByStage, "progress"))
-	c.on("channel.prediction.lock", stageCounter("channel.prediction.lock", &c.st.predictionsByStage, "progress"))
-	c.on("channel.prediction.end", stageCounter("channel.prediction.end", &c.st.predictionsByStage, "end"))
+	c.on("channel.prediction.begin", stageCounter("channel.prediction.begin", "predictions_events", "begin"))
+	c.on("channel.prediction.progress", stageCounter("channel.prediction.progress", "predictions_events", "progress"))
+	c.on("channel.prediction.lock", stageCounter("channel.prediction.lock", "predictions_events", "progress"))
+	c.on("channel.prediction.end", stageCounter("channel.prediction.end", "predictions_events", "end"))
 
-	c.on("channel.charity_campaign.start", stageCounter("channel.charity_campaign.start", &c.st.charityByStage, "begin"))
-	c.on("channel.charity_campaign.progress", stageCounter("channel.charity_campaign.progress", &c.st.charityByStage, "progress"))
-	c.on("channel.charity_campaign.stop", stageCounter("channel.charity_campaign.stop", &c.st.charityByStage, "end"))
+	c.on("channel.charity_campaign.start", stageCounter("channel.charity_campaign.start", "charity_events", "begin"))
+	c.on("channel.charity_campaign.progress", stageCounter("channel.charity_campaign.progress", "charity_events", "progress"))
+	c.on("channel.charity_campaign.stop", stageCounter("channel.charity_campaign.stop", "charity_events", "end"))
 
 	c.on("channel.ban", func(raw json.RawMessage) {
 		c.incNotification("channel.ban")
@@ -419,35 +464,35 @@ func NewEventSubSelfCollector(logger *slog.Logger, client *helix.Client, eventsu
 			action = "timeout"
 		}
 		c.mu.Lock()
-		c.st.moderationByAction[action]++
+		c.count("moderation_actions", action, 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.unban", func(_ json.RawMessage) {
 		c.incNotification("channel.unban")
 		c.mu.Lock()
-		c.st.moderationByAction["unban"]++
+		c.count("moderation_actions", "unban", 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.chat.message_delete", func(_ json.RawMessage) {
 		c.incNotification("channel.chat.message_delete")
 		c.mu.Lock()
-		c.st.moderationByAction["delete"]++
+		c.count("moderation_actions", "delete", 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.shield_mode.begin", func(_ json.RawMessage) {
 		c.incNotification("channel.shield_mode.begin")
 		c.mu.Lock()
-		c.st.moderationByAction["shield_on"]++
+		c.count("moderation_actions", "shield_on", 1)
 		c.mu.Unlock()
 	})
 
 	c.on("channel.shield_mode.end", func(_ json.RawMessage) {
 		c.incNotification("channel.shield_mode.end")
 		c.mu.Lock()
-		c.st.moderationByAction["shield_off"]++
+		c.count("moderation_actions", "shield_off", 1)
 		c.mu.Unlock()
 	})
 
@@ -458,58 +503,101 @@ func (c *eventsubSelfCollector) on(eventType string, cb func(json.RawMessage)) {
 	_ = c.eventsub.On(eventType, cb)
 }
 
-func (c *eventsubSelfCollector) desire(eventType string) {
+func (c *eventsubSelfCollector) desire(eventType string, cond helix.EventSubCondition) {
 	c.mu.Lock()
-	c.desiredTypes[eventType] = true
+	c.desired[eventType] = cond
 	c.mu.Unlock()
 }
 
+// isSelf reports whether a stream.* event is for the self channel.
+func (c *eventsubSelfCollector) isSelf(raw json.RawMessage) bool {
+	var ev struct {
+		BroadcasterUserID string `json:"broadcaster_user_id"`
+	}
+	return json.Unmarshal(raw, &ev) == nil && ev.BroadcasterUserID == c.selfUserID
+}
+
 func (c *eventsubSelfCollector) incNotification(eventType string) {
 	c.mu.Lock()
-	c.st.notifications[eventType]++
+	c.count("notifications", eventType, 1)
 	c.mu.Unlock()
 }
 
+// count adds delta to a counter, optionally labelled, and records it in the
+// state store. Callers hold c.mu.
+func (c *eventsubSelfCollector) count(name string, label string, delta float64) {
+	key := name
+	if label == "" {
+		*c.scalars[name] += delta
+	} else {
+		c.labelled[name][label] += delta
+		key += "/" + label
+	}
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Add(c.selfUserID, key, delta); err != nil {
+		c.logger.Warn("failed to persist eventsub counter", "counter", key, "err", err)
+	}
+}
+
+// restore loads the counters persisted for the self channel. They are keyed by
+// user ID, so totals carry over when the channel is renamed.
+func (c *eventsubSelfCollector) restore() {
+	if c.store == nil {
+		return
+	}
+	counters, err := c.store.Load(c.selfUserID)
+	if err != nil {
+		c.logger.Warn("failed to restore eventsub counters", "err", err)
+		return
+	}
+	for key, v := range counters {
+		name, label, _ := strings.Cut(key, "/")
+		if label == "" {
+			if p, ok := c.scalars[name]; ok {
+				*p += v
+			}
+		} else if m, ok := c.labelled[name]; ok {
+			m[label] += v
+		}
+	}
+	c.logger.Info("restored eventsub counters", "channel", c.selfLogin, "counters", len(counters))
+}
+
+// subscribeApp subscribes to a public event type with the app token.
+func (c *eventsubSelfCollector) subscribeApp(eventType string, cond helix.EventSubCondition) {
+	c.desire(eventType, cond)
+	_ = c.eventsub.SubscribeApp(eventType, "1", cond)
+}
+
 func (c *eventsubSelfCollector) subscribeUserIfScope(eventType string, version string, cond helix.EventSubCondition, requiredScope string) {
 	if !HasUserScope(requiredScope) {
 		IncCollectorDisabled("eventsub_self", "missing_scope")
 		return
 	}
-	c.desire(eventType)
+	c.desire(eventType, cond)
 	if err := c.eventsub.SubscribeUser(eventType, version, cond); err != nil {
 		c.logger.Warn("failed to subscribe to eventsub", "event_type", eventType, "err", err)
 		// keep running; subscription can fail if endpoint isn't reachable yet.
 	}
 }
 
-func (c *eventsubSelfCollector) Update(ch chan<- prometheus.Metric) error {
+func (c *eventsubSelfCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
 	channel := c.selfLogin
 	role := string(RoleSelf)
 
-	// Desired subscriptions.
-	for eventType, desired := range c.desiredTypes {
-		v := 0.0
-		if desired {
-			v = 1.0
-		}
-		ch <- c.subDesired.mustNewConstMetric(v, eventType)
-	}
+	c.mu.Lock()
+	desired := maps.Clone(c.desired)
+	c.mu.Unlock()
 
-	// Active subscriptions (best-effort).
-	active := map[string]bool{}
-	if c.eventsub != nil {
-		subs, err := c.eventsub.ListSubscriptions()
-		if err == nil {
-			for _, s := range subs {
-				if s.Status == "enabled" || s.Status == "webhook_callback_verification_pending" {
-					active[s.Type] = true
-				}
-			}
-		}
-	}
-	for eventType := range c.desiredTypes {
+	// Desired and active subscriptions. Active comes from the reconciler's
+	// view of the self channel's subscriptions, so a scrape does not list
+	// every subscription of the app.
+	for eventType, cond := range desired {
+		ch <- c.subDesired.mustNewConstMetric(1, eventType)
 		v := 0.0
-		if active[eventType] {
+		if c.eventsub != nil && c.eventsub.SubscriptionActive(eventType, cond) {
 			v = 1.0
 		}
 		ch <- c.subActive.mustNewConstMetric(v, eventType)
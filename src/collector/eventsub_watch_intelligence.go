@@ -0,0 +1,308 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webgrip/twitch_exporter/internal/eventsub"
+	"github.com/webgrip/twitch_exporter/internal/statestore"
+)
+
+// EventSub metrics for role=watch channels. Only public topics are used, so an
+// app token is enough, but every subscription counts against the cost budget.
+
+// watchSubscriptions are the public subscriptions created per watched
+// channel. Raids are only subscribed into the channel: a raid out of one
+// watched channel into another would otherwise be delivered twice.
+var watchSubscriptions = []struct {
+	eventType string
+	version   string
+	condition func(id string) helix.EventSubCondition
+}{
+	{"stream.online", "1", func(id string) helix.EventSubCondition { return helix.EventSubCondition{BroadcasterUserID: id} }},
+	{"stream.offline", "1", func(id string) helix.EventSubCondition { return helix.EventSubCondition{BroadcasterUserID: id} }},
+	{"channel.update", "2", func(id string) helix.EventSubCondition { return helix.EventSubCondition{BroadcasterUserID: id} }},
+	{"channel.raid", "1", func(id string) helix.EventSubCondition { return helix.EventSubCondition{ToBroadcasterUserID: id} }},
+}
+
+type eventsubWatchCollector struct {
+	logger    *slog.Logger
+	client    *helix.Client
+	eventsub  *eventsub.Client
+	watchlist *SharedWatchlist
+	store     statestore.Store
+
+	mu sync.Mutex
+	// ids maps the user ID of every subscribed channel to its login.
+	ids map[string]string
+	// skipped holds the logins left unsubscribed because the cost budget
+	// was exhausted; they are tried again on the next watchlist change.
+	skipped       map[string]bool
+	notifications map[string]map[string]float64 // login -> event type
+	raidsIn       map[string]float64
+
+	notificationsTotal typedDesc
+	raidsInTotal       typedDesc
+	channelSubscribed  typedDesc
+	subscriptionCost   typedDesc
+	subscriptionMax    typedDesc
+}
+
+func init() {
+	// Requires EventSub, and every watched channel costs subscriptions.
+	registerCollector("eventsub_watch", defaultDisabled, NewEventSubWatchCollector)
+}
+
+func NewEventSubWatchCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
+	if eventsubClient == nil {
+		IncCollectorDisabled("eventsub_watch", "config_disabled")
+		return noopCollector{}, nil
+	}
+	if client == nil {
+		IncCollectorDisabled("eventsub_watch", "missing_token")
+		return noopCollector{}, nil
+	}
+
+	c := &eventsubWatchCollector{
+		logger:        logger,
+		client:        client,
+		eventsub:      eventsubClient,
+		watchlist:     watchlist,
+		store:         StateStore(),
+		ids:           map[string]string{},
+		skipped:       map[string]bool{},
+		notifications: map[string]map[string]float64{},
+		raidsIn:       map[string]float64{},
+
+		// same series as eventsub_self, for the watched channels
+		notificationsTotal: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "eventsub", "notifications_total"),
+			"Total number of EventSub notifications received.",
+			[]string{"channel", "role", "event_type"}, nil,
+		), prometheus.CounterValue},
+		raidsInTotal: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "channel_raids_in_total"),
+			"Total number of raids into the channel (EventSub).",
+			[]string{"channel"}, nil,
+		), prometheus.CounterValue},
+
+		channelSubscribed: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "eventsub", "watch_channel_subscribed"),
+			"Whether the public EventSub subscriptions for a watched channel were created (1) or skipped, e.g. over the cost budget (0).",
+			[]string{"channel", "role"}, nil,
+		), prometheus.GaugeValue},
+		subscriptionCost: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "eventsub", "subscriptions_cost"),
+			"Total cost of the EventSub subscriptions of the configured transport.",
+			nil, nil,
+		), prometheus.GaugeValue},
+		subscriptionMax: typedDesc{prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "eventsub", "subscriptions_max_cost"),
+			"Maximum total cost Twitch allows for the EventSub subscriptions of the configured transport.",
+			nil, nil,
+		), prometheus.GaugeValue},
+	}
+
+	c.on("stream.online", "broadcaster_user_id")
+	c.on("stream.offline", "broadcaster_user_id")
+	c.on("channel.update", "broadcaster_user_id")
+	c.on("channel.raid", "to_broadcaster_user_id")
+
+	c.subscribe(watchlist.Get().WatchLogins())
+
+	watchlist.OnChange(func(change WatchlistChange) {
+		c.unsubscribe(change.Removed)
+		c.mu.Lock()
+		retry := make([]string, 0, len(c.skipped))
+		for login := range c.skipped {
+			retry = append(retry, login)
+		}
+		c.mu.Unlock()
+		wl := watchlist.Get()
+		var added []string
+		for _, login := range append(retry, change.Added...) {
+			if wl.RoleForLogin(login) == RoleWatch {
+				added = append(added, login)
+			}
+		}
+		c.subscribe(added)
+	})
+
+	return c, nil
+}
+
+// on counts notifications of eventType for the watched channel whose user ID
+// is in field.
+func (c *eventsubWatchCollector) on(eventType string, field string) {
+	_ = c.eventsub.On(eventType, func(raw json.RawMessage) {
+		var ev map[string]any
+		if json.Unmarshal(raw, &ev) != nil {
+			return
+		}
+		id, _ := ev[field].(string)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		login, ok := c.ids[id]
+		if !ok {
+			// another collector's channel
+			return
+		}
+		c.notifications[login][eventType]++
+		c.persist(id, "notifications/"+eventType)
+		if eventType == "channel.raid" {
+			c.raidsIn[login]++
+			c.persist(id, "raids_in")
+		}
+	})
+}
+
+// persist records an increment of the named counter in the state store under
+// the broadcaster's user ID, using the counter names of eventsub_self.
+func (c *eventsubWatchCollector) persist(id string, name string) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Add(id, name, 1); err != nil {
+		c.logger.Warn("failed to persist eventsub counter", "counter", name, "err", err)
+	}
+}
+
+// subscribe creates the public subscriptions for the given logins while the
+// cost budget allows it. Channels that do not fit are skipped as a whole.
+func (c *eventsubWatchCollector) subscribe(logins []string) {
+	if len(logins) == 0 {
+		return
+	}
+	users, err := resolveUsers(context.Background(), c.client, logins)
+	if err != nil {
+		c.logger.Error("failed to resolve watched channels for eventsub", "err", err)
+		return
+	}
+
+	total, limit, ok := c.eventsub.SubscriptionCost()
+	if !ok {
+		var err error
+		if total, limit, err = c.eventsub.RefreshSubscriptionCost(); err != nil {
+			// no guard without the budget; Twitch rejects what does not fit
+			c.logger.Warn("failed to get eventsub subscription cost", "err", err)
+			limit = 0
+		}
+	}
+
+	for _, user := range users {
+		login := normalizeLogin(user.Login)
+		// subscriptions channel_core already asked for are not created again
+		cost := 0
+		for _, s := range watchSubscriptions {
+			if !c.eventsub.Subscribed(s.eventType, s.condition(user.ID)) {
+				cost++
+			}
+		}
+		if limit > 0 && total+cost > limit {
+			c.logger.Warn("eventsub cost budget exhausted; not subscribing watched channel", "channel", login, "cost", total, "max_cost", limit)
+			c.mu.Lock()
+			c.skipped[login] = true
+			c.mu.Unlock()
+			continue
+		}
+		total += cost
+
+		c.mu.Lock()
+		delete(c.skipped, login)
+		c.ids[user.ID] = login
+		if _, ok := c.notifications[login]; !ok {
+			c.notifications[login] = map[string]float64{}
+			c.raidsIn[login] = 0
+			c.restore(user.ID, login)
+		}
+		c.mu.Unlock()
+
+		for _, s := range watchSubscriptions {
+			if err := c.eventsub.SubscribeApp(s.eventType, s.version, s.condition(user.ID)); err != nil {
+				// the reconciler creates it later
+				c.logger.Warn("failed to subscribe to eventsub", "event_type", s.eventType, "channel", login, "err", err)
+			}
+		}
+	}
+}
+
+// restore loads the persisted counters of a channel. Callers hold c.mu.
+func (c *eventsubWatchCollector) restore(id string, login string) {
+	if c.store == nil {
+		return
+	}
+	counters, err := c.store.Load(id)
+	if err != nil {
+		c.logger.Warn("failed to restore eventsub counters", "channel", login, "err", err)
+		return
+	}
+	for _, s := range watchSubscriptions {
+		if v, ok := counters["notifications/"+s.eventType]; ok {
+			c.notifications[login][s.eventType] = v
+		}
+	}
+	c.raidsIn[login] = counters["raids_in"]
+}
+
+// unsubscribe drops the subscriptions and counters of removed channels.
+func (c *eventsubWatchCollector) unsubscribe(logins []string) {
+	for _, login := range logins {
+		c.mu.Lock()
+		delete(c.skipped, login)
+		delete(c.notifications, login)
+		delete(c.raidsIn, login)
+		id := ""
+		for uid, l := range c.ids {
+			if l == login {
+				id = uid
+				delete(c.ids, uid)
+			}
+		}
+		c.mu.Unlock()
+		if id == "" {
+			continue
+		}
+		for _, s := range watchSubscriptions {
+			if err := c.eventsub.Unsubscribe(s.eventType, s.condition(id)); err != nil {
+				c.logger.Warn("failed to unsubscribe from eventsub", "event_type", s.eventType, "channel", login, "err", err)
+			}
+		}
+	}
+}
+
+func (c *eventsubWatchCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	wl := c.watchlist.Get()
+	if wl.CountByRole(RoleWatch) == 0 {
+		return ErrNoData
+	}
+	role := string(RoleWatch)
+
+	// cached from subscribing and reconciling, scrapes never list subscriptions
+	if total, limit, ok := c.eventsub.SubscriptionCost(); ok {
+		ch <- c.subscriptionCost.mustNewConstMetric(float64(total))
+		ch <- c.subscriptionMax.mustNewConstMetric(float64(limit))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, login := range wl.WatchLogins() {
+		_, subscribed := c.notifications[login]
+		if !subscribed && !c.skipped[login] {
+			// not resolved (yet)
+			continue
+		}
+		ch <- c.channelSubscribed.mustNewConstMetric(boolToFloat(subscribed), login, role)
+	}
+	for login, byType := range c.notifications {
+		for eventType, v := range byType {
+			ch <- c.notificationsTotal.mustNewConstMetric(v, login, role, eventType)
+		}
+		ch <- c.raidsInTotal.mustNewConstMetric(c.raidsIn[login], login)
+	}
+	return nil
+}
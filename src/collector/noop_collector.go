@@ -1,7 +1,11 @@
 package collector
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type noopCollector struct{}
 
-func (noopCollector) Update(ch chan<- prometheus.Metric) error { return nil }
+func (noopCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error { return nil }
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/webgrip/twitch_exporter/internal/eventsub"
+)
+
+// eventsubCollectors only report what long-lived EventSub subscriptions
+// delivered, so a one-off probe has nothing to show for them.
+var eventsubCollectors = map[string]bool{
+	"eventsub_self":               true,
+	"eventsub_watch":              true,
+	"channel_chat_messages_total": true,
+}
+
+// probeFactories build collectors that run differently for a one-off probe.
+var probeFactories = map[string]func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error){
+	// a one-off poll cannot follow a stream; sessions come from the main
+	// exporter
+	"channel_core": func(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
+		return newChannelCoreCollector(logger, client, eventsubClient, watchlist, nil)
+	},
+}
+
+// ValidateProbeCollectors checks that names are known collectors that can run
+// against a one-off watchlist.
+func ValidateProbeCollectors(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no collectors configured")
+	}
+	for _, name := range names {
+		if _, ok := factories[name]; !ok {
+			return fmt.Errorf("unknown collector: %s", name)
+		}
+		if eventsubCollectors[name] {
+			return fmt.Errorf("collector %s depends on EventSub and cannot be probed", name)
+		}
+	}
+	return nil
+}
+
+// NewProbeExporter builds fresh collectors for a single channel. Collector
+// state is not shared with the main exporter, but the Helix client (and with
+// it rate-limit accounting) and the user resolver cache are. Runtime metrics
+// are left to the main /metrics endpoint.
+func NewProbeExporter(logger *slog.Logger, client *helix.Client, target string, role ChannelRole, names []string) (*Exporter, error) {
+	if err := ValidateProbeCollectors(names); err != nil {
+		return nil, err
+	}
+
+	var (
+		wl  ChannelWatchlist
+		err error
+	)
+	if role == RoleSelf {
+		wl, err = NewChannelWatchlist(target, nil, 0)
+	} else {
+		wl, err = NewChannelWatchlist("", []string{target}, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{
+		client:    client,
+		watchlist: NewSharedWatchlist(wl),
+		logger:    logger,
+		probe:     true,
+	}
+
+	collectors := make(map[string]Collector, len(names))
+	for _, name := range names {
+		factory, ok := probeFactories[name]
+		if !ok {
+			factory = factories[name]
+		}
+		c, err := factory(logger, client, nil, e.watchlist)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		collectors[name] = c
+	}
+	e.Collectors = collectors
+	return e, nil
+}
@@ -0,0 +1,35 @@
+package collector
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/webgrip/twitch_exporter/internal/sessions"
+)
+
+func TestProbeChannelCoreHasNoSessions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	SetSessionTracker(sessions.NewTracker(sessions.NewHistory(1), logger))
+	t.Cleanup(func() { SetSessionTracker(nil) })
+
+	wl, err := NewChannelWatchlist("me", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChannelCoreCollector(logger, nil, nil, NewSharedWatchlist(wl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.(*channelCoreCollector).sessions == nil {
+		t.Fatal("the main channel_core does not record sessions")
+	}
+
+	e, err := NewProbeExporter(logger, nil, "me", RoleWatch, []string{"channel_core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc := e.Collectors["channel_core"].(*channelCoreCollector); cc.sessions != nil {
+		t.Error("the probed channel_core records sessions")
+	}
+}
@@ -27,7 +27,23 @@ type rewardGroupConfig struct {
 	byTitle map[string]string
 }
 
+// RewardGrouping is a validated reward grouping that can be applied later,
+// so a config reload can validate everything before changing anything.
+type RewardGrouping struct {
+	cfg rewardGroupConfig
+}
+
+// SetRewardGrouping validates and applies a reward grouping in one step.
 func SetRewardGrouping(defaultGroup string, unknownGroup string, maxGroups int, byID map[string]string, byTitle map[string]string) error {
+	g, err := NewRewardGrouping(defaultGroup, unknownGroup, maxGroups, byID, byTitle)
+	if err != nil {
+		return err
+	}
+	ApplyRewardGrouping(g)
+	return nil
+}
+
+func NewRewardGrouping(defaultGroup string, unknownGroup string, maxGroups int, byID map[string]string, byTitle map[string]string) (RewardGrouping, error) {
 	if strings.TrimSpace(defaultGroup) == "" {
 		defaultGroup = "default"
 	}
@@ -51,7 +67,7 @@ func SetRewardGrouping(defaultGroup string, unknownGroup string, maxGroups int,
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
-		return fmt.Errorf("reward_group cardinality too high: %d groups (max %d): %v", len(groups), maxGroups, keys)
+		return RewardGrouping{}, fmt.Errorf("reward_group cardinality too high: %d groups (max %d): %v", len(groups), maxGroups, keys)
 	}
 
 	normID := map[string]string{}
@@ -74,16 +90,26 @@ func SetRewardGrouping(defaultGroup string, unknownGroup string, maxGroups int,
 		normTitle[k] = v
 	}
 
-	rewardGroupMu.Lock()
-	defer rewardGroupMu.Unlock()
-	rewardGroups = rewardGroupConfig{
+	return RewardGrouping{cfg: rewardGroupConfig{
 		defaultGroup: defaultGroup,
 		unknownGroup: unknownGroup,
 		maxGroups:    maxGroups,
 		byID:         normID,
 		byTitle:      normTitle,
-	}
-	return nil
+	}}, nil
+}
+
+func ApplyRewardGrouping(g RewardGrouping) {
+	rewardGroupMu.Lock()
+	defer rewardGroupMu.Unlock()
+	rewardGroups = g.cfg
+}
+
+// CurrentRewardGrouping returns the grouping in use, e.g. to restore it.
+func CurrentRewardGrouping() RewardGrouping {
+	rewardGroupMu.RLock()
+	defer rewardGroupMu.RUnlock()
+	return RewardGrouping{cfg: rewardGroups}
 }
 
 func RewardGroupFor(rewardID string, rewardTitle string) string {
@@ -1,6 +1,9 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"strconv"
@@ -9,6 +12,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webgrip/twitch_exporter/internal/eventsub"
 )
 
 var (
@@ -23,12 +27,30 @@ type runtimeMetrics struct {
 
 	oauthTokenPresent *prometheus.GaugeVec
 	oauthScopePresent *prometheus.GaugeVec
+	oauthExpiresAt    *prometheus.GaugeVec
+	oauthRefresh      *prometheus.CounterVec
 
 	apiRequestsTotal      *prometheus.CounterVec
 	apiRateLimitRemaining *prometheus.GaugeVec
 	apiRateLimitResetAt   *prometheus.GaugeVec
+	apiQueueWait          *prometheus.HistogramVec
+	apiRetries            *prometheus.CounterVec
+	apiRequestDuration    *prometheus.HistogramVec
+	apiTransportErrors    *prometheus.CounterVec
 
 	eventsubSignatureFail *prometheus.CounterVec
+	eventsubDuplicates    prometheus.Counter
+	eventsubRevocations   *prometheus.CounterVec
+	eventsubResubscribes  *prometheus.CounterVec
+	reconcileRuns         *prometheus.CounterVec
+	reconcileChanges      *prometheus.CounterVec
+	reconcileSubs         *prometheus.GaugeVec
+	reconcileLastSuccess  prometheus.Gauge
+	conduitShards         *prometheus.GaugeVec
+	conduitShardEnabled   prometheus.Gauge
+
+	userResolverLookups      *prometheus.CounterVec
+	userResolverCacheEntries prometheus.Gauge
 }
 
 func getRuntimeMetrics() *runtimeMetrics {
@@ -55,6 +77,14 @@ func getRuntimeMetrics() *runtimeMetrics {
 				Name: prometheus.BuildFQName(namespace, "oauth", "scope_present"),
 				Help: "Whether a known OAuth scope is present on the validated user token (1 = yes, 0 = no).",
 			}, []string{"scope"}),
+			oauthExpiresAt: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "oauth", "token_expires_at_seconds"),
+				Help: "Unix timestamp at which the current OAuth token expires (0 = unknown).",
+			}, []string{"token_type"}),
+			oauthRefresh: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "oauth", "refresh_total"),
+				Help: "Total number of OAuth token refreshes by result (success, failure).",
+			}, []string{"token_type", "result"}),
 
 			apiRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: prometheus.BuildFQName(namespace, "api", "requests_total"),
@@ -68,10 +98,73 @@ func getRuntimeMetrics() *runtimeMetrics {
 				Name: prometheus.BuildFQName(namespace, "api", "rate_limit_reset_at_seconds"),
 				Help: "Unix timestamp when the Twitch API rate limit resets, if provided by response headers.",
 			}, []string{"api"}),
+			apiQueueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    prometheus.BuildFQName(namespace, "api", "queue_wait_seconds"),
+				Help:    "Time requests waited for rate-limit points before being sent.",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+			}, []string{"api"}),
+			apiRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "api", "retries_total"),
+				Help: "Total number of retried API requests by reason (rate_limited, server_error).",
+			}, []string{"api", "endpoint", "reason"}),
+			apiRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    prometheus.BuildFQName(namespace, "api", "request_duration_seconds"),
+				Help:    "Duration of Twitch API HTTP requests, including failed ones.",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			}, []string{"api", "endpoint"}),
+			apiTransportErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "api", "transport_errors_total"),
+				Help: "Total number of Twitch API requests that failed without a response, by kind (timeout, dns, tls, reset, other).",
+			}, []string{"api", "endpoint", "kind"}),
 			eventsubSignatureFail: prometheus.NewCounterVec(prometheus.CounterOpts{
 				Name: prometheus.BuildFQName(namespace, "eventsub", "signature_fail_total"),
-				Help: "Total number of EventSub webhook signature verification failures.",
+				Help: "Total number of EventSub webhook signature verification failures by reason (missing_headers, bad_signature, stale).",
 			}, []string{"reason"}),
+			eventsubDuplicates: prometheus.NewCounter(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "duplicates_total"),
+				Help: "Total number of redelivered EventSub notifications that were dropped.",
+			}),
+			eventsubRevocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "revocations_total"),
+				Help: "Total number of EventSub subscriptions revoked by Twitch, by type and reason.",
+			}, []string{"event_type", "reason"}),
+			eventsubResubscribes: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "resubscriptions_total"),
+				Help: "Total number of attempts to recreate revoked EventSub subscriptions, by result (success, failure).",
+			}, []string{"event_type", "result"}),
+			reconcileRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "reconcile_runs_total"),
+				Help: "Total number of EventSub subscription reconcile passes by result (success, failure).",
+			}, []string{"result"}),
+			reconcileChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "reconcile_changes_total"),
+				Help: "Total number of subscriptions changed by the reconciler by action (created, deleted, failed).",
+			}, []string{"action"}),
+			reconcileSubs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "reconcile_subscriptions"),
+				Help: "Subscriptions seen by the last reconcile pass by state (desired, active).",
+			}, []string{"state"}),
+			reconcileLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "reconcile_last_success_timestamp_seconds"),
+				Help: "Unix timestamp of the last successful reconcile pass.",
+			}),
+			conduitShards: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "conduit_shards"),
+				Help: "Number of shards of the EventSub conduit by status.",
+			}, []string{"status"}),
+			conduitShardEnabled: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "eventsub", "conduit_shard_enabled"),
+				Help: "Whether the conduit shard owned by this exporter is enabled (1/0).",
+			}),
+
+			userResolverLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: prometheus.BuildFQName(namespace, "user_resolver", "lookups_total"),
+				Help: "Total number of login to user ID lookups by cache result (hit, negative_hit, miss).",
+			}, []string{"result"}),
+			userResolverCacheEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: prometheus.BuildFQName(namespace, "user_resolver", "cache_entries"),
+				Help: "Number of logins currently cached by the user resolver, including unknown logins.",
+			}),
 		}
 	})
 	return metrics
@@ -86,10 +179,27 @@ func runtimeCollectors() []prometheus.Collector {
 		m.collectorDisabled,
 		m.oauthTokenPresent,
 		m.oauthScopePresent,
+		m.oauthExpiresAt,
+		m.oauthRefresh,
 		m.apiRequestsTotal,
 		m.apiRateLimitRemaining,
 		m.apiRateLimitResetAt,
+		m.apiQueueWait,
+		m.apiRetries,
+		m.apiRequestDuration,
+		m.apiTransportErrors,
 		m.eventsubSignatureFail,
+		m.eventsubDuplicates,
+		m.eventsubRevocations,
+		m.eventsubResubscribes,
+		m.reconcileRuns,
+		m.reconcileChanges,
+		m.reconcileSubs,
+		m.reconcileLastSuccess,
+		m.conduitShards,
+		m.conduitShardEnabled,
+		m.userResolverLookups,
+		m.userResolverCacheEntries,
 	}
 }
 
@@ -101,6 +211,19 @@ func SetOAuthTokenPresent(tokenType string, present bool) {
 	getRuntimeMetrics().oauthTokenPresent.WithLabelValues(tokenType).Set(v)
 }
 
+// SetOAuthTokenExpiresAt records when the current token expires; a zero time means unknown.
+func SetOAuthTokenExpiresAt(tokenType string, expiresAt time.Time) {
+	v := 0.0
+	if !expiresAt.IsZero() {
+		v = float64(expiresAt.Unix())
+	}
+	getRuntimeMetrics().oauthExpiresAt.WithLabelValues(tokenType).Set(v)
+}
+
+func IncOAuthRefresh(tokenType string, result string) {
+	getRuntimeMetrics().oauthRefresh.WithLabelValues(tokenType, result).Inc()
+}
+
 // SetKnownOAuthScopes sets the known scope gauges to 0/1. Scopes not in knownScopes are ignored.
 func SetKnownOAuthScopes(knownScopes []string, presentScopes []string) {
 	m := getRuntimeMetrics()
@@ -130,6 +253,22 @@ func ObserveCollectorError(collectorName string, reason string) {
 	getRuntimeMetrics().collectorErrorsTotal.WithLabelValues(collectorName, reason).Inc()
 }
 
+func ObserveAPIQueueWait(api string, wait time.Duration) {
+	getRuntimeMetrics().apiQueueWait.WithLabelValues(api).Observe(wait.Seconds())
+}
+
+func IncAPIRetry(api string, endpoint string, reason string) {
+	getRuntimeMetrics().apiRetries.WithLabelValues(api, endpoint, reason).Inc()
+}
+
+func ObserveAPIRequestDuration(api string, endpoint string, d time.Duration) {
+	getRuntimeMetrics().apiRequestDuration.WithLabelValues(api, endpoint).Observe(d.Seconds())
+}
+
+func IncAPITransportError(api string, endpoint string, kind string) {
+	getRuntimeMetrics().apiTransportErrors.WithLabelValues(api, endpoint, kind).Inc()
+}
+
 func ObserveAPIResponse(api string, endpoint string, statusCode int, headers http.Header) {
 	m := getRuntimeMetrics()
 	codeClass := "other"
@@ -165,26 +304,86 @@ func IncEventSubSignatureFail(reason string) {
 	getRuntimeMetrics().eventsubSignatureFail.WithLabelValues(reason).Inc()
 }
 
+func IncEventSubDuplicate() {
+	getRuntimeMetrics().eventsubDuplicates.Inc()
+}
+
+func IncEventSubRevocation(eventType string, reason string) {
+	getRuntimeMetrics().eventsubRevocations.WithLabelValues(eventType, reason).Inc()
+}
+
+func IncEventSubResubscribe(eventType string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	getRuntimeMetrics().eventsubResubscribes.WithLabelValues(eventType, result).Inc()
+}
+
+func ObserveEventSubReconcile(res eventsub.ReconcileResult) {
+	m := getRuntimeMetrics()
+	if res.Err != nil {
+		m.reconcileRuns.WithLabelValues("failure").Inc()
+		return
+	}
+	m.reconcileRuns.WithLabelValues("success").Inc()
+	m.reconcileChanges.WithLabelValues("created").Add(float64(res.Created))
+	m.reconcileChanges.WithLabelValues("deleted").Add(float64(res.Deleted))
+	m.reconcileChanges.WithLabelValues("failed").Add(float64(res.Failed))
+	m.reconcileSubs.WithLabelValues("desired").Set(float64(res.Desired))
+	m.reconcileSubs.WithLabelValues("active").Set(float64(res.Active))
+	m.reconcileLastSuccess.SetToCurrentTime()
+}
+
+// SetEventSubConduitShards publishes a conduit shard status poll. The counts
+// replace the previous poll, so statuses no shard has anymore disappear.
+func SetEventSubConduitShards(counts map[string]int, own string) {
+	m := getRuntimeMetrics()
+	m.conduitShards.Reset()
+	for status, n := range counts {
+		m.conduitShards.WithLabelValues(status).Set(float64(n))
+	}
+	if own == "enabled" {
+		m.conduitShardEnabled.Set(1)
+	} else {
+		m.conduitShardEnabled.Set(0)
+	}
+}
+
+func ObserveUserResolverLookup(result string) {
+	getRuntimeMetrics().userResolverLookups.WithLabelValues(result).Inc()
+}
+
+func SetUserResolverCacheEntries(n int) {
+	getRuntimeMetrics().userResolverCacheEntries.Set(float64(n))
+}
+
 func ClassifyErrorReason(err error) string {
 	if err == nil {
 		return "other"
 	}
-	if ne, ok := err.(net.Error); ok {
-		if ne.Timeout() {
-			return "timeout"
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return "auth"
+		case apiErr.StatusCode >= 500:
+			return "http_5xx"
+		default:
+			return "http_4xx"
 		}
 	}
-	msg := strings.ToLower(err.Error())
+	var (
+		netErr    net.Error
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
 	switch {
-	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "ratelimit") || strings.Contains(msg, "429"):
-		return "rate_limited"
-	case strings.Contains(msg, "401") || strings.Contains(msg, "unauthorized") || strings.Contains(msg, "invalid oauth"):
-		return "auth"
-	case strings.Contains(msg, "status 4") || strings.Contains(msg, " 4"):
-		return "http_4xx"
-	case strings.Contains(msg, "status 5") || strings.Contains(msg, " 5"):
-		return "http_5xx"
-	case strings.Contains(msg, "json") || strings.Contains(msg, "decode") || strings.Contains(msg, "unmarshal"):
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
 		return "decode"
 	default:
 		return "other"
@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "collector", "cache_age_seconds"),
+	"Seconds since a background collector last finished a run. Only exported for collectors with an interval.",
+	[]string{"collector"},
+	nil,
+)
+
+// scheduledJob runs one collector on its own interval and keeps the metrics
+// from its last run, so scrapes are served from memory.
+type scheduledJob struct {
+	exporter  *Exporter
+	name      string
+	collector Collector
+	interval  time.Duration
+	stop      chan struct{}
+
+	mu      sync.RWMutex
+	metrics []prometheus.Metric
+	lastRun time.Time
+}
+
+func (j *scheduledJob) start() {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.refresh()
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh runs the collector once. Without a configured timeout a run may
+// take at most one interval.
+func (j *scheduledJob) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), j.interval)
+	defer cancel()
+	ctx, cancelTimeout := j.exporter.collectorContext(ctx, j.name)
+	defer cancelTimeout()
+	metrics := collectMetrics(ctx, j.name, j.collector, j.exporter.logger)
+
+	j.mu.Lock()
+	j.metrics = metrics
+	j.lastRun = time.Now()
+	j.mu.Unlock()
+}
+
+// collect replays the cached metrics. Nothing is sent before the first run finishes.
+func (j *scheduledJob) collect(ch chan<- prometheus.Metric) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.lastRun.IsZero() {
+		return
+	}
+	for _, m := range j.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(j.lastRun).Seconds(), j.name)
+}
+
+// collectMetrics runs a collector once and buffers everything it emits.
+func collectMetrics(ctx context.Context, name string, c Collector, logger *slog.Logger) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	execute(ctx, name, c, ch, logger)
+	close(ch)
+	return <-done
+}
+
+// SetIntervals moves collectors to background polling. Collectors with an
+// interval of 0 keep running synchronously on every scrape; intervals holds
+// per-collector overrides of defaultInterval.
+func (e *Exporter) SetIntervals(defaultInterval time.Duration, intervals map[string]time.Duration) error {
+	if err := validateIntervals(defaultInterval, intervals); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaultInterval = defaultInterval
+	e.intervals = copyDurationMap(intervals)
+	e.syncJobs()
+	return nil
+}
+
+// intervalAware is implemented by collectors whose results depend on how often
+// they run. syncJobs tells them their interval, 0 when they run on every scrape.
+type intervalAware interface {
+	setInterval(interval time.Duration)
+}
+
+func validateIntervals(defaultInterval time.Duration, intervals map[string]time.Duration) error {
+	if defaultInterval < 0 {
+		return fmt.Errorf("collector interval must not be negative: %s", defaultInterval)
+	}
+	if err := validateCollectorNames(intervals); err != nil {
+		return err
+	}
+	for name, interval := range intervals {
+		if interval < 0 {
+			return fmt.Errorf("collector %s: interval must not be negative: %s", name, interval)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) intervalFor(name string) time.Duration {
+	if interval, ok := e.intervals[name]; ok {
+		return interval
+	}
+	return e.defaultInterval
+}
+
+// syncJobs starts, restarts and stops background jobs to match the enabled
+// collectors and their intervals. e.mu must be held.
+func (e *Exporter) syncJobs() {
+	jobs := make(map[string]*scheduledJob, len(e.jobs))
+	for name, job := range e.jobs {
+		c, ok := e.Collectors[name]
+		if ok && c == job.collector && e.intervalFor(name) == job.interval {
+			jobs[name] = job
+			continue
+		}
+		close(job.stop)
+		e.logger.Info("stopped background collector", "collector", name)
+	}
+
+	for name, c := range e.Collectors {
+		interval := e.intervalFor(name)
+		if ic, ok := c.(intervalAware); ok {
+			ic.setInterval(interval)
+		}
+		if interval == 0 {
+			continue
+		}
+		if _, ok := jobs[name]; ok {
+			continue
+		}
+		job := &scheduledJob{
+			exporter:  e,
+			name:      name,
+			collector: c,
+			interval:  interval,
+			stop:      make(chan struct{}),
+		}
+		jobs[name] = job
+		job.start()
+		e.logger.Info("started background collector", "collector", name, "interval", interval)
+	}
+
+	// Collect reads e.jobs without holding e.mu, so swap in a new map
+	// instead of mutating the one it may be ranging over.
+	e.jobs = jobs
+}
+
+func copyDurationMap(in map[string]time.Duration) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
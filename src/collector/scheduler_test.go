@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingCollector counts its runs.
+type countingCollector struct {
+	runs atomic.Int32
+}
+
+func (c *countingCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	c.runs.Add(1)
+	return nil
+}
+
+// scrape collects e once and returns how many collectors were served from
+// a background job.
+func scrape(e *Exporter) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		e.Collect(ch)
+		close(ch)
+	}()
+	cached := 0
+	for m := range ch {
+		if m.Desc() == cacheAgeDesc {
+			cached++
+		}
+	}
+	return cached
+}
+
+func TestSetIntervals(t *testing.T) {
+	polled, scraped := &countingCollector{}, &countingCollector{}
+	e := &Exporter{
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Collectors: map[string]Collector{"channel_core": polled, "channel_up": scraped},
+	}
+	t.Cleanup(func() { _ = e.SetIntervals(0, nil) })
+
+	if err := e.SetIntervals(0, map[string]time.Duration{"channel_core": time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the first background run", func() bool { return scrape(e) == 1 })
+	job := e.jobs["channel_core"]
+
+	scraped.runs.Store(0)
+	for range 3 {
+		scrape(e)
+	}
+	if polled.runs.Load() != 1 || scraped.runs.Load() != 3 {
+		t.Errorf("runs = %d background, %d synchronous; want 1 and 3", polled.runs.Load(), scraped.runs.Load())
+	}
+
+	if err := e.SetIntervals(time.Hour, map[string]time.Duration{"channel_core": time.Hour, "channel_up": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if e.jobs["channel_core"] != job {
+		t.Error("an unchanged interval restarted the job")
+	}
+	if _, ok := e.jobs["channel_up"]; ok {
+		t.Error("an interval of 0 started a job")
+	}
+
+	if err := e.SetIntervals(0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.jobs) != 0 {
+		t.Errorf("jobs = %v after removing the intervals", e.jobs)
+	}
+	select {
+	case <-job.stop:
+	default:
+		t.Error("the removed job was not stopped")
+	}
+
+	for _, tc := range []struct {
+		name      string
+		interval  time.Duration
+		intervals map[string]time.Duration
+	}{
+		{"negative default", -time.Second, nil},
+		{"negative interval", 0, map[string]time.Duration{"channel_core": -time.Second}},
+		{"unknown collector", 0, map[string]time.Duration{"nope": time.Second}},
+	} {
+		if err := e.SetIntervals(tc.interval, tc.intervals); err == nil {
+			t.Errorf("%s: SetIntervals accepted it", tc.name)
+		}
+	}
+}
+
+// eventually waits up to a few seconds for cond.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,28 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/webgrip/twitch_exporter/internal/statestore"
+)
+
+var (
+	stateStore    statestore.Store
+	stateStoreMtx sync.RWMutex
+)
+
+// SetStateStore sets where collectors persist counters that cannot be read
+// back from the Twitch API. It applies to collectors created afterwards; nil
+// keeps the counters in memory only.
+func SetStateStore(store statestore.Store) {
+	stateStoreMtx.Lock()
+	stateStore = store
+	stateStoreMtx.Unlock()
+}
+
+// StateStore returns the store set by SetStateStore, or nil.
+func StateStore() statestore.Store {
+	stateStoreMtx.RLock()
+	defer stateStoreMtx.RUnlock()
+	return stateStore
+}
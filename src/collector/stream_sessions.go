@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/webgrip/twitch_exporter/internal/sessions"
+)
+
+var (
+	sessionTracker    *sessions.Tracker
+	sessionTrackerMtx sync.RWMutex
+)
+
+// SetSessionTracker sets the tracker channel_core records stream sessions in.
+// It applies to collectors created afterwards; nil disables session tracking.
+func SetSessionTracker(tracker *sessions.Tracker) {
+	sessionTrackerMtx.Lock()
+	sessionTracker = tracker
+	sessionTrackerMtx.Unlock()
+}
+
+// SessionTracker returns the tracker set by SetSessionTracker, or nil.
+func SessionTracker() *sessions.Tracker {
+	sessionTrackerMtx.RLock()
+	defer sessionTrackerMtx.RUnlock()
+	return sessionTracker
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var helixBinder atomic.Pointer[func(context.Context, *helix.Client) *helix.Client]
+
+// SetHelixContextBinder installs fn to derive a Helix client whose requests
+// carry ctx, so rate-limit waits and retries inside the HTTP client end at the
+// collector deadline instead of running on after the scrape gave up.
+func SetHelixContextBinder(fn func(ctx context.Context, client *helix.Client) *helix.Client) {
+	helixBinder.Store(&fn)
+}
+
+// withContext returns client bound to ctx, or client itself when no binder
+// is installed.
+func withContext(ctx context.Context, client *helix.Client) *helix.Client {
+	fn := helixBinder.Load()
+	if fn == nil || client == nil {
+		return client
+	}
+	return (*fn)(ctx, client)
+}
+
+// SetTimeouts bounds how long a collector may run. defaultTimeout applies to
+// every collector without an entry in timeouts; 0 leaves only the scrape
+// deadline.
+func (e *Exporter) SetTimeouts(defaultTimeout time.Duration, timeouts map[string]time.Duration) error {
+	if err := validateTimeouts(defaultTimeout, timeouts); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaultTimeout = defaultTimeout
+	e.timeouts = copyDurationMap(timeouts)
+	return nil
+}
+
+func validateTimeouts(defaultTimeout time.Duration, timeouts map[string]time.Duration) error {
+	if defaultTimeout < 0 {
+		return fmt.Errorf("collector timeout must not be negative: %s", defaultTimeout)
+	}
+	if err := validateCollectorNames(timeouts); err != nil {
+		return err
+	}
+	for name, timeout := range timeouts {
+		if timeout < 0 {
+			return fmt.Errorf("collector %s: timeout must not be negative: %s", name, timeout)
+		}
+	}
+	return nil
+}
+
+// collectorContext derives the context for one collector run. A per-collector
+// timeout can only shorten the deadline already set on ctx.
+func (e *Exporter) collectorContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	e.mu.RLock()
+	timeout, ok := e.timeouts[name]
+	if !ok {
+		timeout = e.defaultTimeout
+	}
+	e.mu.RUnlock()
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runUpdate runs c.Update and buffers its metrics. If ctx ends first it
+// returns without waiting, so a hung Helix request cannot hold up the scrape;
+// whatever the collector sends afterwards is discarded.
+func runUpdate(ctx context.Context, c Collector) ([]prometheus.Metric, error) {
+	type result struct {
+		metrics []prometheus.Metric
+		err     error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		ch := make(chan prometheus.Metric)
+		drained := make(chan []prometheus.Metric)
+		go func() {
+			var metrics []prometheus.Metric
+			for m := range ch {
+				metrics = append(metrics, m)
+			}
+			drained <- metrics
+		}()
+		err := c.Update(ctx, ch)
+		close(ch)
+		done <- result{metrics: <-drained, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.metrics, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("collector did not finish in time: %w", ctx.Err())
+	}
+}
+
+// errorReason classifies the error of a collector run. A run whose context
+// ended timed out, whatever Update returned: helix flattens transport errors,
+// context.DeadlineExceeded included, into plain strings.
+func errorReason(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return "timeout"
+	}
+	return ClassifyErrorReason(err)
+}
+
+// WithContext returns a prometheus.Collector that runs the exporter's
+// collectors bound to ctx, typically the scrape deadline of one request.
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return contextExporter{exporter: e, ctx: ctx}
+}
+
+type contextExporter struct {
+	exporter *Exporter
+	ctx      context.Context
+}
+
+func (c contextExporter) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+}
+
+func (c contextExporter) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collect(c.ctx, ch)
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestErrorReason(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	live := context.Background()
+	// helix reports failed requests this way, dropping the error chain
+	flattened := errors.New("Failed to execute API request: Get \"https://api.twitch.tv/helix/streams\": context deadline exceeded")
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{"flattened deadline", expired, flattened, "timeout"},
+		{"other error after the deadline", expired, errors.New("boom"), "timeout"},
+		{"canceled", canceled, errors.New("boom"), "timeout"},
+		{"deadline of a request", live, fmt.Errorf("get: %w", context.DeadlineExceeded), "timeout"},
+		{"flattened error before the deadline", live, flattened, "other"},
+		{"plain error", live, errors.New("boom"), "other"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := errorReason(tc.ctx, tc.err); got != tc.want {
+				t.Errorf("errorReason = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+type collectorFunc func(ctx context.Context, ch chan<- prometheus.Metric) error
+
+func (f collectorFunc) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return f(ctx, ch)
+}
+
+func TestRunUpdateReturnsAtTheDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hung := collectorFunc(func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	metrics, err := runUpdate(ctx, hung)
+	if !errors.Is(err, context.DeadlineExceeded) || metrics != nil {
+		t.Fatalf("runUpdate = %v, %v; want no metrics and the deadline", metrics, err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("runUpdate waited %s for a hung collector", d)
+	}
+}
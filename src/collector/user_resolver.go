@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// sharedUserResolver caches login -> user lookups for all collectors so the
+// same logins are not resolved through GetUsers on every scrape.
+var sharedUserResolver = newUserResolver(15*time.Minute, 5*time.Minute)
+
+type userResolver struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]userResolverEntry
+}
+
+type userResolverEntry struct {
+	user      helix.User
+	found     bool
+	expiresAt time.Time
+}
+
+func newUserResolver(ttl time.Duration, negativeTTL time.Duration) *userResolver {
+	return &userResolver{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     map[string]userResolverEntry{},
+	}
+}
+
+// SetUserResolverTTL configures how long resolved and unknown logins stay cached.
+func SetUserResolverTTL(ttl time.Duration, negativeTTL time.Duration) {
+	r := sharedUserResolver
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ttl = ttl
+	r.negativeTTL = negativeTTL
+}
+
+// resolveUsers returns the users for logins in input order, skipping logins
+// Twitch does not know. Cache misses are fetched in batches of 100.
+func resolveUsers(ctx context.Context, client *helix.Client, logins []string) ([]helix.User, error) {
+	return sharedUserResolver.resolve(ctx, client, logins)
+}
+
+// resolveUser returns a single user and whether the login exists.
+func resolveUser(ctx context.Context, client *helix.Client, login string) (helix.User, bool, error) {
+	users, err := resolveUsers(ctx, client, []string{login})
+	if err != nil || len(users) == 0 {
+		return helix.User{}, false, err
+	}
+	return users[0], true, nil
+}
+
+func (r *userResolver) resolve(ctx context.Context, client *helix.Client, logins []string) ([]helix.User, error) {
+	now := time.Now()
+	normalized := make([]string, 0, len(logins))
+	missing := []string{}
+
+	r.mu.Lock()
+	for _, raw := range logins {
+		login := normalizeLogin(raw)
+		if login == "" {
+			continue
+		}
+		normalized = append(normalized, login)
+		entry, ok := r.entries[login]
+		switch {
+		case ok && now.Before(entry.expiresAt) && entry.found:
+			ObserveUserResolverLookup("hit")
+		case ok && now.Before(entry.expiresAt):
+			ObserveUserResolverLookup("negative_hit")
+		default:
+			ObserveUserResolverLookup("miss")
+			missing = append(missing, login)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(missing) > 0 {
+		if client == nil {
+			return nil, errors.New("helix client not configured")
+		}
+		if err := r.fetch(ctx, client, missing, now); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := make([]helix.User, 0, len(normalized))
+	for _, login := range normalized {
+		if entry, ok := r.entries[login]; ok && entry.found {
+			users = append(users, entry.user)
+		}
+	}
+	return users, nil
+}
+
+func (r *userResolver) fetch(ctx context.Context, client *helix.Client, logins []string, now time.Time) error {
+	client = withContext(ctx, client)
+	return forEachBatch(ctx, logins, func(batch []string) error {
+		resp, err := client.GetUsers(&helix.UsersParams{Logins: batch})
+		if err != nil {
+			return err
+		}
+		if err := checkResponse("users", resp.ResponseCommon); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		found := map[string]struct{}{}
+		for _, u := range resp.Data.Users {
+			login := normalizeLogin(u.Login)
+			found[login] = struct{}{}
+			r.entries[login] = userResolverEntry{user: u, found: true, expiresAt: now.Add(r.ttl)}
+		}
+		// Logins Helix did not return are unknown (renamed, banned or typos).
+		for _, login := range batch {
+			if _, ok := found[login]; !ok {
+				r.entries[login] = userResolverEntry{expiresAt: now.Add(r.negativeTTL)}
+			}
+		}
+		r.sweep(now)
+		SetUserResolverCacheEntries(len(r.entries))
+		return nil
+	})
+}
+
+// sweep drops expired entries. r.mu must be held.
+func (r *userResolver) sweep(now time.Time) {
+	for login, entry := range r.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(r.entries, login)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// fakeUsers serves GET /users for the known logins and records the logins of
+// every request.
+type fakeUsers struct {
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func newFakeUsers(t *testing.T, known ...string) (*fakeUsers, *helix.Client) {
+	f := &fakeUsers{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logins := r.URL.Query()["login"]
+		f.mu.Lock()
+		f.requests = append(f.requests, logins)
+		f.mu.Unlock()
+		users := []helix.User{}
+		for _, login := range logins {
+			if slices.Contains(known, login) {
+				users = append(users, helix.User{ID: "id-" + login, Login: login})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": users})
+	}))
+	t.Cleanup(srv.Close)
+	client, err := helix.NewClient(&helix.Options{ClientID: "client", AppAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+func (f *fakeUsers) take() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.requests
+	f.requests = nil
+	return r
+}
+
+func TestUserResolver(t *testing.T) {
+	fake, client := newFakeUsers(t, "alice", "bob")
+	r := newUserResolver(time.Hour, time.Hour)
+
+	// the cases run in order against the same cache
+	cases := []struct {
+		name         string
+		logins       []string
+		wantIDs      []string
+		wantRequests [][]string
+	}{
+		{
+			name:         "misses are fetched",
+			logins:       []string{"Bob", "alice", "nobody"},
+			wantIDs:      []string{"id-bob", "id-alice"},
+			wantRequests: [][]string{{"bob", "alice", "nobody"}},
+		},
+		{
+			name:    "hits and unknown logins are cached",
+			logins:  []string{"alice", "nobody", "bob"},
+			wantIDs: []string{"id-alice", "id-bob"},
+		},
+		{
+			name:         "only new logins are fetched",
+			logins:       []string{"alice", "carol"},
+			wantIDs:      []string{"id-alice"},
+			wantRequests: [][]string{{"carol"}},
+		},
+		{
+			name:   "empty logins are skipped",
+			logins: []string{" ", ""},
+		},
+	}
+	for _, tc := range cases {
+		users, err := r.resolve(context.Background(), client, tc.logins)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var ids []string
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if !slices.Equal(ids, tc.wantIDs) {
+			t.Errorf("%s: ids = %v, want %v", tc.name, ids, tc.wantIDs)
+		}
+		if got := fake.take(); !slices.EqualFunc(got, tc.wantRequests, slices.Equal) {
+			t.Errorf("%s: requests = %v, want %v", tc.name, got, tc.wantRequests)
+		}
+	}
+}
+
+func TestUserResolverExpiry(t *testing.T) {
+	fake, client := newFakeUsers(t, "alice")
+	// found logins expire at once, unknown ones are kept
+	r := newUserResolver(0, time.Hour)
+
+	for range 2 {
+		if _, err := r.resolve(context.Background(), client, []string{"alice", "nobody"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := [][]string{{"alice", "nobody"}, {"alice"}}
+	if got := fake.take(); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries["alice"]; ok {
+		t.Error("expired entry was not swept")
+	}
+}
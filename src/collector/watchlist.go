@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 )
 
 type ChannelRole string
@@ -14,18 +15,25 @@ const (
 	RoleWatch ChannelRole = "watch"
 )
 
+// DefaultMaxWatchChannels is the default cap on role=watch channels.
+const DefaultMaxWatchChannels = 100
+
 type ChannelWatchlist struct {
 	// logins are always stored normalized (lowercase).
 	selfLogin string
 	watch     []string
+	maxWatch  int
 
 	roleByLogin map[string]ChannelRole
 }
 
-func NewChannelWatchlist(selfLogin string, watchLogins []string) (ChannelWatchlist, error) {
+// NewChannelWatchlist builds a watchlist with at most maxWatch role=watch
+// channels. A maxWatch of 0 or less disables the limit.
+func NewChannelWatchlist(selfLogin string, watchLogins []string, maxWatch int) (ChannelWatchlist, error) {
 	wl := ChannelWatchlist{
 		selfLogin:   normalizeLogin(selfLogin),
 		watch:       make([]string, 0, len(watchLogins)),
+		maxWatch:    maxWatch,
 		roleByLogin: map[string]ChannelRole{},
 	}
 
@@ -51,8 +59,8 @@ func NewChannelWatchlist(selfLogin string, watchLogins []string) (ChannelWatchli
 		wl.roleByLogin[login] = RoleWatch
 	}
 
-	if len(wl.watch) > 100 {
-		return wl, fmt.Errorf("watchlist role=watch exceeds 100 channels (%d)", len(wl.watch))
+	if maxWatch > 0 && len(wl.watch) > maxWatch {
+		return wl, fmt.Errorf("watchlist role=watch exceeds %d channels (%d)", maxWatch, len(wl.watch))
 	}
 
 	sort.Strings(wl.watch)
@@ -112,6 +120,150 @@ func (w ChannelWatchlist) ValidateHasSelf() error {
 	return nil
 }
 
+// SharedWatchlist is a concurrency-safe handle to the current ChannelWatchlist.
+// Collectors hold the handle and take a snapshot on every Update, so a config
+// reload or the admin API can change the watchlist without rebuilding
+// collectors or losing state.
+type SharedWatchlist struct {
+	// changeMu serializes changes together with their notification, so
+	// listeners see the diffs in the order the changes were made.
+	changeMu sync.Mutex
+
+	mu sync.RWMutex
+	wl ChannelWatchlist
+
+	listenersMu sync.Mutex
+	listeners   []func(WatchlistChange)
+}
+
+// WatchlistChange lists the logins added to and removed from a SharedWatchlist.
+type WatchlistChange struct {
+	Added   []string
+	Removed []string
+}
+
+func NewSharedWatchlist(wl ChannelWatchlist) *SharedWatchlist {
+	return &SharedWatchlist{wl: wl}
+}
+
+// Get returns the current watchlist snapshot.
+func (s *SharedWatchlist) Get() ChannelWatchlist {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.wl
+}
+
+// Set replaces the current watchlist and notifies listeners of the difference.
+// commit, if not nil, runs after the swap and before the notification; when it
+// fails the previous watchlist is restored and nobody is notified.
+func (s *SharedWatchlist) Set(wl ChannelWatchlist, commit func() error) error {
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	prev := s.swap(wl)
+	if commit != nil {
+		if err := commit(); err != nil {
+			s.swap(prev)
+			return err
+		}
+	}
+	s.notify(diffWatchlists(prev, wl))
+	return nil
+}
+
+// AddWatch adds a role=watch login. It returns false if the login was already present.
+func (s *SharedWatchlist) AddWatch(login string) (bool, error) {
+	login = normalizeLogin(login)
+	if login == "" {
+		return false, errors.New("empty channel login")
+	}
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	prev := s.Get()
+	if prev.RoleForLogin(login) != "" {
+		return false, nil
+	}
+	next, err := NewChannelWatchlist(prev.selfLogin, append(prev.WatchLogins(), login), prev.maxWatch)
+	if err != nil {
+		return false, err
+	}
+	s.swap(next)
+	s.notify(diffWatchlists(prev, next))
+	return true, nil
+}
+
+// RemoveWatch removes a role=watch login. It returns false if the login was not watched.
+func (s *SharedWatchlist) RemoveWatch(login string) (bool, error) {
+	login = normalizeLogin(login)
+
+	s.changeMu.Lock()
+	defer s.changeMu.Unlock()
+	prev := s.Get()
+	switch prev.RoleForLogin(login) {
+	case RoleWatch:
+	case RoleSelf:
+		return false, errors.New("the self channel can only be changed through configuration")
+	default:
+		return false, nil
+	}
+	watch := make([]string, 0, len(prev.watch))
+	for _, l := range prev.watch {
+		if l != login {
+			watch = append(watch, l)
+		}
+	}
+	next, err := NewChannelWatchlist(prev.selfLogin, watch, prev.maxWatch)
+	if err != nil {
+		return false, err
+	}
+	s.swap(next)
+	s.notify(diffWatchlists(prev, next))
+	return true, nil
+}
+
+// OnChange registers a callback invoked after logins are added or removed.
+// Callbacks run one change at a time and must not change the watchlist.
+func (s *SharedWatchlist) OnChange(fn func(WatchlistChange)) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *SharedWatchlist) swap(wl ChannelWatchlist) ChannelWatchlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.wl
+	s.wl = wl
+	return prev
+}
+
+func (s *SharedWatchlist) notify(change WatchlistChange) {
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return
+	}
+	s.listenersMu.Lock()
+	listeners := append([]func(WatchlistChange){}, s.listeners...)
+	s.listenersMu.Unlock()
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+func diffWatchlists(prev, next ChannelWatchlist) WatchlistChange {
+	change := WatchlistChange{}
+	for _, login := range next.AllLogins() {
+		if prev.RoleForLogin(login) == "" {
+			change.Added = append(change.Added, login)
+		}
+	}
+	for _, login := range prev.AllLogins() {
+		if next.RoleForLogin(login) == "" {
+			change.Removed = append(change.Removed, login)
+		}
+	}
+	return change
+}
+
 func normalizeLogin(v string) string {
 	return strings.ToLower(strings.TrimSpace(v))
 }
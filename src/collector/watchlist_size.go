@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/nicklaw5/helix/v2"
@@ -10,7 +11,7 @@ import (
 
 type watchlistSizeCollector struct {
 	logger    *slog.Logger
-	watchlist ChannelWatchlist
+	watchlist *SharedWatchlist
 
 	watchlistSize typedDesc
 }
@@ -19,7 +20,7 @@ func init() {
 	registerCollector("watchlist", defaultEnabled, NewWatchlistSizeCollector)
 }
 
-func NewWatchlistSizeCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist ChannelWatchlist) (Collector, error) {
+func NewWatchlistSizeCollector(logger *slog.Logger, client *helix.Client, eventsubClient *eventsub.Client, watchlist *SharedWatchlist) (Collector, error) {
 	c := watchlistSizeCollector{
 		logger:    logger,
 		watchlist: watchlist,
@@ -33,8 +34,9 @@ func NewWatchlistSizeCollector(logger *slog.Logger, client *helix.Client, events
 	return c, nil
 }
 
-func (c watchlistSizeCollector) Update(ch chan<- prometheus.Metric) error {
-	ch <- c.watchlistSize.mustNewConstMetric(float64(c.watchlist.CountByRole(RoleSelf)), string(RoleSelf))
-	ch <- c.watchlistSize.mustNewConstMetric(float64(c.watchlist.CountByRole(RoleWatch)), string(RoleWatch))
+func (c watchlistSizeCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	wl := c.watchlist.Get()
+	ch <- c.watchlistSize.mustNewConstMetric(float64(wl.CountByRole(RoleSelf)), string(RoleSelf))
+	ch <- c.watchlistSize.mustNewConstMetric(float64(wl.CountByRole(RoleWatch)), string(RoleWatch))
 	return nil
 }
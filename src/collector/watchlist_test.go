@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustWatchlist(t *testing.T, self string, watch ...string) ChannelWatchlist {
+	t.Helper()
+	wl, err := NewChannelWatchlist(self, watch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wl
+}
+
+func TestNewChannelWatchlist(t *testing.T) {
+	cases := []struct {
+		name      string
+		self      string
+		watch     []string
+		max       int
+		wantWatch []string
+		wantErr   bool
+	}{
+		{name: "normalized and sorted", self: "Me", watch: []string{" Bob ", "alice"}, wantWatch: []string{"alice", "bob"}},
+		{name: "duplicates and self dropped", self: "me", watch: []string{"bob", "BOB", "me", ""}, wantWatch: []string{"bob"}},
+		{name: "at the limit", watch: []string{"a", "b"}, max: 2, wantWatch: []string{"a", "b"}},
+		{name: "over the limit", watch: []string{"a", "b", "c"}, max: 2, wantErr: true},
+		{name: "no limit", watch: []string{"a", "b", "c"}, max: 0, wantWatch: []string{"a", "b", "c"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wl, err := NewChannelWatchlist(tc.self, tc.watch, tc.max)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := wl.WatchLogins(); !slices.Equal(got, tc.wantWatch) {
+				t.Errorf("WatchLogins = %v, want %v", got, tc.wantWatch)
+			}
+			if tc.self != "" && wl.RoleForLogin(tc.self) != RoleSelf {
+				t.Errorf("role of %q = %q, want self", tc.self, wl.RoleForLogin(tc.self))
+			}
+		})
+	}
+}
+
+func TestDiffWatchlists(t *testing.T) {
+	cases := []struct {
+		name        string
+		prev, next  ChannelWatchlist
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "unchanged", prev: mustWatchlist(t, "me", "a"), next: mustWatchlist(t, "me", "a")},
+		{name: "added", prev: mustWatchlist(t, "me", "a"), next: mustWatchlist(t, "me", "a", "b"), wantAdded: []string{"b"}},
+		{name: "removed", prev: mustWatchlist(t, "me", "a", "b"), next: mustWatchlist(t, "me", "b"), wantRemoved: []string{"a"}},
+		{name: "self changed", prev: mustWatchlist(t, "me"), next: mustWatchlist(t, "you"), wantAdded: []string{"you"}, wantRemoved: []string{"me"}},
+		// a role change keeps the login, so listeners are not told
+		{name: "watch becomes self", prev: mustWatchlist(t, "me", "a"), next: mustWatchlist(t, "a"), wantRemoved: []string{"me"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffWatchlists(tc.prev, tc.next)
+			if !slices.Equal(got.Added, tc.wantAdded) || !slices.Equal(got.Removed, tc.wantRemoved) {
+				t.Errorf("diff = %+v, want added %v removed %v", got, tc.wantAdded, tc.wantRemoved)
+			}
+		})
+	}
+}
+
+// recorder collects the changes a SharedWatchlist notifies.
+type recorder struct {
+	mu      sync.Mutex
+	changes []WatchlistChange
+}
+
+func (r *recorder) record(c WatchlistChange) {
+	r.mu.Lock()
+	r.changes = append(r.changes, c)
+	r.mu.Unlock()
+}
+
+func TestSharedWatchlistChanges(t *testing.T) {
+	s := NewSharedWatchlist(mustWatchlist(t, "me", "a"))
+	var r recorder
+	s.OnChange(r.record)
+
+	cases := []struct {
+		name   string
+		change func() (bool, error)
+		want   bool
+		err    bool
+		watch  []string
+	}{
+		{name: "add", change: func() (bool, error) { return s.AddWatch("B") }, want: true, watch: []string{"a", "b"}},
+		{name: "add again", change: func() (bool, error) { return s.AddWatch("b") }, watch: []string{"a", "b"}},
+		{name: "add self", change: func() (bool, error) { return s.AddWatch("me") }, watch: []string{"a", "b"}},
+		{name: "add empty", change: func() (bool, error) { return s.AddWatch(" ") }, err: true, watch: []string{"a", "b"}},
+		{name: "remove", change: func() (bool, error) { return s.RemoveWatch("a") }, want: true, watch: []string{"b"}},
+		{name: "remove unknown", change: func() (bool, error) { return s.RemoveWatch("zzz") }, watch: []string{"b"}},
+		{name: "remove self", change: func() (bool, error) { return s.RemoveWatch("me") }, err: true, watch: []string{"b"}},
+	}
+	for _, tc := range cases {
+		got, err := tc.change()
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("%s: got %v, %v; want %v, error %v", tc.name, got, err, tc.want, tc.err)
+		}
+		if w := s.Get().WatchLogins(); !slices.Equal(w, tc.watch) {
+			t.Errorf("%s: watch = %v, want %v", tc.name, w, tc.watch)
+		}
+	}
+
+	want := []WatchlistChange{{Added: []string{"b"}}, {Removed: []string{"a"}}}
+	if len(r.changes) != len(want) {
+		t.Fatalf("notified %+v, want %+v", r.changes, want)
+	}
+	for i := range want {
+		if !slices.Equal(r.changes[i].Added, want[i].Added) || !slices.Equal(r.changes[i].Removed, want[i].Removed) {
+			t.Errorf("change %d = %+v, want %+v", i, r.changes[i], want[i])
+		}
+	}
+}
+
+func TestSharedWatchlistSet(t *testing.T) {
+	initial := mustWatchlist(t, "me", "a")
+	next := mustWatchlist(t, "me", "b")
+	cases := []struct {
+		name       string
+		commit     func() error
+		wantWatch  []string
+		wantNotify bool
+	}{
+		{name: "no commit", wantWatch: []string{"b"}, wantNotify: true},
+		{name: "commit succeeds", commit: func() error { return nil }, wantWatch: []string{"b"}, wantNotify: true},
+		{name: "commit fails", commit: func() error { return errors.New("boom") }, wantWatch: []string{"a"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSharedWatchlist(initial)
+			var r recorder
+			s.OnChange(r.record)
+
+			var during []string
+			commit := tc.commit
+			if commit != nil {
+				commit = func() error {
+					// the commit sees the new watchlist
+					during = s.Get().WatchLogins()
+					return tc.commit()
+				}
+			}
+			err := s.Set(next, commit)
+			if (err != nil) == tc.wantNotify {
+				t.Errorf("Set error = %v", err)
+			}
+			if commit != nil && !slices.Equal(during, []string{"b"}) {
+				t.Errorf("commit saw %v, want the new watchlist", during)
+			}
+			if got := s.Get().WatchLogins(); !slices.Equal(got, tc.wantWatch) {
+				t.Errorf("watch = %v, want %v", got, tc.wantWatch)
+			}
+			if notified := len(r.changes) > 0; notified != tc.wantNotify {
+				t.Errorf("notified = %v, want %v", notified, tc.wantNotify)
+			}
+		})
+	}
+}
+
+// TestSharedWatchlistSetSerializesChanges checks that an admin change made
+// while a reload commits is applied on top of the reloaded watchlist, and that
+// listeners see both changes in order.
+func TestSharedWatchlistSetSerializesChanges(t *testing.T) {
+	s := NewSharedWatchlist(mustWatchlist(t, "me", "a"))
+	var r recorder
+	s.OnChange(r.record)
+
+	committing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.Set(mustWatchlist(t, "me", "b"), func() error {
+			close(committing)
+			<-release
+			return nil
+		})
+	}()
+	<-committing
+
+	added := make(chan error)
+	go func() {
+		_, err := s.AddWatch("c")
+		added <- err
+	}()
+	select {
+	case <-added:
+		t.Fatal("AddWatch did not wait for the reload to commit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Get().WatchLogins(); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("watch = %v, want [b c]", got)
+	}
+	got := fmt.Sprint(r.changes)
+	if want := fmt.Sprint([]WatchlistChange{{Added: []string{"b"}, Removed: []string{"a"}}, {Added: []string{"c"}}}); got != want {
+		t.Errorf("changes = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webgrip/twitch_exporter/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the YAML layout of --config.file. Every section is optional;
+// anything left out falls back to the equivalent command line flags.
+type fileConfig struct {
+	Watchlist      watchlistFileConfig            `yaml:"watchlist"`
+	RewardGroups   rewardGroupsFileConfig         `yaml:"reward_groups"`
+	CategoryGroups categoryGroupsFileConfig       `yaml:"category_groups"`
+	Collectors     map[string]collectorFileConfig `yaml:"collectors"`
+	Modules        map[string]probeModuleConfig   `yaml:"modules"`
+}
+
+type watchlistFileConfig struct {
+	Self     string   `yaml:"self"`
+	Watch    []string `yaml:"watch"`
+	MaxWatch int      `yaml:"max_watch"`
+}
+
+type rewardGroupsFileConfig struct {
+	Default string            `yaml:"default"`
+	Unknown string            `yaml:"unknown"`
+	Max     int               `yaml:"max"`
+	ByID    map[string]string `yaml:"by_id"`
+	ByTitle map[string]string `yaml:"by_title"`
+}
+
+type categoryGroupsFileConfig struct {
+	Default string            `yaml:"default"`
+	Unknown string            `yaml:"unknown"`
+	Max     int               `yaml:"max"`
+	ByID    map[string]string `yaml:"by_id"`
+	ByName  map[string]string `yaml:"by_name"`
+}
+
+type collectorFileConfig struct {
+	Enabled  *bool         `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// probeModuleConfig names the collectors /probe runs for a module.
+type probeModuleConfig struct {
+	Collectors []string `yaml:"collectors"`
+}
+
+func loadConfigFile(path string) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// runtimeConfig is the validated result of merging flags and the config file.
+type runtimeConfig struct {
+	watchlist        collector.ChannelWatchlist
+	rewardGrouping   collector.RewardGrouping
+	categoryGrouping collector.CategoryGrouping
+	collectors       map[string]bool
+	intervals        map[string]time.Duration
+	timeouts         map[string]time.Duration
+	modules          map[string][]string
+}
+
+// resolveConfig merges the config file on top of the command line flags.
+// Scalars set in the file win, watch channels and group mappings are merged.
+func resolveConfig(cfg *fileConfig) (runtimeConfig, error) {
+	selfLogin := strings.TrimSpace(*twitchSelfChannel)
+	legacyLogins := (*twitchChannel)
+	if selfLogin == "" && len(legacyLogins) > 0 {
+		// Backwards-compatible default: treat first legacy channel as self.
+		selfLogin = legacyLogins[0]
+	}
+	if cfg.Watchlist.Self != "" {
+		selfLogin = cfg.Watchlist.Self
+	}
+
+	watchLogins := make([]string, 0, len(*twitchWatchChannels)+max(0, len(legacyLogins)-1)+len(cfg.Watchlist.Watch))
+	watchLogins = append(watchLogins, (*twitchWatchChannels)...)
+	if len(legacyLogins) > 1 {
+		watchLogins = append(watchLogins, legacyLogins[1:]...)
+	}
+	watchLogins = append(watchLogins, cfg.Watchlist.Watch...)
+
+	maxWatch := *twitchWatchChannelsMax
+	if cfg.Watchlist.MaxWatch != 0 {
+		maxWatch = cfg.Watchlist.MaxWatch
+	}
+
+	watchlist, err := collector.NewChannelWatchlist(selfLogin, watchLogins, maxWatch)
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid watchlist configuration: %w", err)
+	}
+
+	rg := cfg.RewardGroups
+	defaultGroup := firstNonEmpty(rg.Default, *rewardGroupDefault)
+	unknownGroup := firstNonEmpty(rg.Unknown, *rewardGroupUnknown)
+	maxGroups := *rewardGroupMax
+	if rg.Max > 0 {
+		maxGroups = rg.Max
+	}
+	byID := mergeStringMaps(map[string]string(*rewardGroupByID), rg.ByID)
+	byTitle := mergeStringMaps(map[string]string(*rewardGroupByTitle), rg.ByTitle)
+
+	grouping, err := collector.NewRewardGrouping(defaultGroup, unknownGroup, maxGroups, byID, byTitle)
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid reward group configuration: %w", err)
+	}
+
+	cg := cfg.CategoryGroups
+	maxCategoryGroups := *categoryGroupMax
+	if cg.Max > 0 {
+		maxCategoryGroups = cg.Max
+	}
+	categoryGrouping, err := collector.NewCategoryGrouping(
+		firstNonEmpty(cg.Default, *categoryGroupDefault),
+		firstNonEmpty(cg.Unknown, *categoryGroupUnknown),
+		maxCategoryGroups,
+		mergeStringMaps(map[string]string(*categoryGroupByID), cg.ByID),
+		mergeStringMaps(map[string]string(*categoryGroupByName), cg.ByName),
+	)
+	if err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid category group configuration: %w", err)
+	}
+
+	collectors := map[string]bool{}
+	intervals := map[string]time.Duration{}
+	timeouts := map[string]time.Duration{}
+	for name, c := range cfg.Collectors {
+		if c.Enabled != nil {
+			collectors[name] = *c.Enabled
+		}
+		if c.Interval != 0 {
+			intervals[name] = c.Interval
+		}
+		if c.Timeout != 0 {
+			timeouts[name] = c.Timeout
+		}
+	}
+
+	modules := map[string][]string{}
+	for name, m := range cfg.Modules {
+		if err := collector.ValidateProbeCollectors(m.Collectors); err != nil {
+			return runtimeConfig{}, fmt.Errorf("invalid module %s: %w", name, err)
+		}
+		modules[name] = m.Collectors
+	}
+
+	return runtimeConfig{
+		watchlist:        watchlist,
+		rewardGrouping:   grouping,
+		categoryGrouping: categoryGrouping,
+		collectors:       collectors,
+		intervals:        intervals,
+		timeouts:         timeouts,
+		modules:          modules,
+	}, nil
+}
+
+func (rc runtimeConfig) collectorSettings() collector.CollectorSettings {
+	return collector.CollectorSettings{
+		Enabled:   rc.collectors,
+		Intervals: rc.intervals,
+		Timeouts:  rc.timeouts,
+	}
+}
+
+// reloader re-reads --config.file and applies it to a running exporter.
+type reloader struct {
+	mu         sync.Mutex
+	logger     *slog.Logger
+	configFile string
+	exporter   *collector.Exporter
+	modules    *probeModules
+
+	lastSuccessful prometheus.Gauge
+	lastSuccessAt  prometheus.Gauge
+}
+
+func newReloader(logger *slog.Logger, configFile string, exporter *collector.Exporter, modules *probeModules) *reloader {
+	return &reloader{
+		logger:     logger,
+		configFile: configFile,
+		exporter:   exporter,
+		modules:    modules,
+		lastSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "twitch_exporter_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful (1 = yes, 0 = no).",
+		}),
+		lastSuccessAt: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "twitch_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful configuration reload.",
+		}),
+	}
+}
+
+func (r *reloader) collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.lastSuccessful, r.lastSuccessAt}
+}
+
+// markLoaded records the configuration applied at startup.
+func (r *reloader) markLoaded() {
+	r.lastSuccessful.Set(1)
+	r.lastSuccessAt.SetToCurrentTime()
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.apply()
+	if err != nil {
+		r.lastSuccessful.Set(0)
+		r.logger.Error("configuration reload failed", "file", r.configFile, "err", err)
+		return err
+	}
+	r.markLoaded()
+	r.logger.Info("configuration reloaded", "file", r.configFile)
+	return nil
+}
+
+// apply validates everything first and only then swaps the reward and
+// category grouping, watchlist, collector set and probe modules, so a bad file
+// changes nothing. The groupings go first: a scrape that sees the new
+// watchlist labels it with the new groups.
+func (r *reloader) apply() error {
+	if r.configFile == "" {
+		return errors.New("no --config.file configured")
+	}
+	cfg, err := loadConfigFile(r.configFile)
+	if err != nil {
+		return err
+	}
+	rc, err := resolveConfig(cfg)
+	if err != nil {
+		return err
+	}
+	prevSelf := r.exporter.Watchlist().Get().SelfLogin()
+	prevReward, prevCategory := collector.CurrentRewardGrouping(), collector.CurrentCategoryGrouping()
+	collector.ApplyRewardGrouping(rc.rewardGrouping)
+	collector.ApplyCategoryGrouping(rc.categoryGrouping)
+	if err := r.exporter.Reload(rc.watchlist, rc.collectorSettings()); err != nil {
+		collector.ApplyRewardGrouping(prevReward)
+		collector.ApplyCategoryGrouping(prevCategory)
+		return err
+	}
+	if self := rc.watchlist.SelfLogin(); self != prevSelf {
+		r.logger.Warn("self channel changed; EventSub subscriptions for the self channel are only recreated on restart", "previous", prevSelf, "current", self)
+	}
+	r.modules.set(rc.modules)
+	return nil
+}
+
+// watchSignals reloads the configuration on SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = r.reload()
+		}
+	}()
+}
+
+// Handler serves POST /-/reload.
+func (r *reloader) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func mergeStringMaps(base map[string]string, override map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
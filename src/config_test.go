@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/webgrip/twitch_exporter/collector"
+)
+
+var parseTestFlags = sync.OnceFunc(func() {
+	_, err := kingpin.CommandLine.Parse([]string{
+		"--twitch.client-id=id",
+		"--twitch.self-channel=flagself",
+		"--twitch.watch-channel=flagwatch",
+		"--twitch.reward-group.id=r1:flaggroup",
+		"--twitch.category-group.name=Minecraft:games",
+	})
+	if err != nil {
+		panic(err)
+	}
+})
+
+func TestResolveConfig(t *testing.T) {
+	parseTestFlags()
+	enabled := true
+	cases := []struct {
+		name      string
+		cfg       fileConfig
+		wantErr   bool
+		wantSelf  string
+		wantWatch []string
+		check     func(t *testing.T, rc runtimeConfig)
+	}{
+		{name: "flags only", wantSelf: "flagself", wantWatch: []string{"flagwatch"}},
+		{
+			name:      "file self wins, watch merged",
+			cfg:       fileConfig{Watchlist: watchlistFileConfig{Self: "FileSelf", Watch: []string{"filewatch", "flagwatch"}}},
+			wantSelf:  "fileself",
+			wantWatch: []string{"filewatch", "flagwatch"},
+		},
+		{
+			name:    "file max_watch",
+			cfg:     fileConfig{Watchlist: watchlistFileConfig{Watch: []string{"a", "b"}, MaxWatch: 2}},
+			wantErr: true,
+		},
+		{
+			name:    "reward groups over the max",
+			cfg:     fileConfig{RewardGroups: rewardGroupsFileConfig{Max: 2, ByTitle: map[string]string{"a": "x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "category groups over the max",
+			cfg:     fileConfig{CategoryGroups: categoryGroupsFileConfig{Max: 2, ByID: map[string]string{"1": "x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "module with unknown collector",
+			cfg:     fileConfig{Modules: map[string]probeModuleConfig{"m": {Collectors: []string{"nope"}}}},
+			wantErr: true,
+		},
+		{
+			name: "collector settings",
+			cfg: fileConfig{Collectors: map[string]collectorFileConfig{
+				"channel_up": {Enabled: &enabled, Interval: time.Minute},
+				"watchlist":  {Timeout: time.Second},
+			}},
+			wantSelf:  "flagself",
+			wantWatch: []string{"flagwatch"},
+			check: func(t *testing.T, rc runtimeConfig) {
+				s := rc.collectorSettings()
+				if !s.Enabled["channel_up"] || len(s.Enabled) != 1 {
+					t.Errorf("enabled = %v", s.Enabled)
+				}
+				if s.Intervals["channel_up"] != time.Minute || s.Timeouts["watchlist"] != time.Second {
+					t.Errorf("intervals = %v, timeouts = %v", s.Intervals, s.Timeouts)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, err := resolveConfig(&tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rc.watchlist.SelfLogin(); got != tc.wantSelf {
+				t.Errorf("self = %q, want %q", got, tc.wantSelf)
+			}
+			if got := rc.watchlist.WatchLogins(); !slices.Equal(got, tc.wantWatch) {
+				t.Errorf("watch = %v, want %v", got, tc.wantWatch)
+			}
+			if tc.check != nil {
+				tc.check(t, rc)
+			}
+		})
+	}
+}
+
+func TestReloaderApply(t *testing.T) {
+	parseTestFlags()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rc, err := resolveConfig(&fileConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.ApplyCategoryGrouping(rc.categoryGrouping)
+	watchlist := collector.NewSharedWatchlist(rc.watchlist)
+	exporter, err := collector.NewExporter(logger, nil, nil, watchlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yml")
+	r := newReloader(logger, path, exporter, &probeModules{})
+
+	cases := []struct {
+		name      string
+		file      string
+		wantErr   bool
+		wantWatch []string
+		wantGroup string // of the Minecraft category
+	}{
+		{
+			name:      "unknown collector changes nothing",
+			file:      "watchlist:\n  watch: [new]\ncategory_groups:\n  by_name:\n    Minecraft: blocks\ncollectors:\n  nope:\n    enabled: true\n",
+			wantErr:   true,
+			wantWatch: []string{"flagwatch"},
+			wantGroup: "games",
+		},
+		{
+			name:      "unparsable file changes nothing",
+			file:      "watchlist: [",
+			wantErr:   true,
+			wantWatch: []string{"flagwatch"},
+			wantGroup: "games",
+		},
+		{
+			name:      "valid file",
+			file:      "watchlist:\n  watch: [new]\ncategory_groups:\n  by_name:\n    Minecraft: blocks\n",
+			wantWatch: []string{"flagwatch", "new"},
+			wantGroup: "blocks",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tc.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			err := r.reload()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("reload error = %v, want error %v", err, tc.wantErr)
+			}
+			if got := watchlist.Get().WatchLogins(); !slices.Equal(got, tc.wantWatch) {
+				t.Errorf("watch = %v, want %v", got, tc.wantWatch)
+			}
+			if got := collector.CategoryGroupFor("", "Minecraft"); got != tc.wantGroup {
+				t.Errorf("category group = %q, want %q", got, tc.wantGroup)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/webgrip/twitch_exporter/collector"
+	"github.com/webgrip/twitch_exporter/internal/eventsub"
+)
+
+// newEventSubClient sets up the EventSub transport selected by the flags. It
+// returns nil, disabling EventSub, when the configuration is incomplete.
+func newEventSubClient(logger *slog.Logger, client *helix.Client, clientType string) *eventsub.Client {
+	if client == nil {
+		logger.Error("eventsub enabled but Twitch client is not configured; disabling eventsub")
+		return nil
+	}
+
+	var userClient *helix.Client
+	if clientType == "user" {
+		userClient = client
+	}
+
+	var appClient *helix.Client
+	if *eventSubTransport == eventsub.TransportWebhook || *eventSubConduitEnabled {
+		var err error
+		// eventsub requires an app client to create webhooks and conduits, but we may have created a user
+		// client beforehand for subscription metrics, so just check and create the app client if needed
+		if clientType == "user" {
+			appClient, err = newClientWithSecret(logger, newInstrumentedHTTPClient("eventsub"))
+			if err != nil {
+				logger.Error("Error creating the client", "err", err)
+				os.Exit(1)
+			}
+		} else {
+			// Create a dedicated client so EventSub subscription management is tracked separately.
+			appClient, err = newClientWithSecret(logger, newInstrumentedHTTPClient("eventsub"))
+			if err != nil {
+				logger.Error("Error creating eventsub client", "err", err)
+				return nil
+			}
+		}
+	}
+
+	var (
+		eventsubClient *eventsub.Client
+		err            error
+	)
+	if *eventSubTransport == eventsub.TransportWebSocket {
+		if userClient == nil && !*eventSubConduitEnabled {
+			logger.Error("eventsub websocket transport requires --twitch.access-token or a conduit; disabling eventsub")
+			return nil
+		}
+		logger.Info("eventsub websocket transport enabled", "url", *eventSubWebSocketURL)
+		eventsubClient, err = eventsub.NewWebSocket(*eventSubWebSocketURL, logger, userClient)
+	} else {
+		if *eventSubWebhookURL == "" || *eventSubWebhookSecret == "" {
+			logger.Error("eventsub enabled but webhook URL/secret are missing; disabling eventsub")
+			return nil
+		}
+		logger.Info("eventsub endpoint enabled", "endpoint", "/eventsub")
+		eventsubClient, err = eventsub.New(
+			*twitchClientID,
+			*twitchClientSecret,
+			*eventSubWebhookURL,
+			*eventSubWebhookSecret,
+			logger,
+			appClient,
+			userClient,
+		)
+	}
+	if err != nil {
+		logger.Error("Error creating the eventsub client", "err", err)
+		return nil
+	}
+
+	eventsubClient.SetDuplicateHook(collector.IncEventSubDuplicate)
+	eventsubClient.SetRevocationHook(collector.IncEventSubRevocation)
+	eventsubClient.SetResubscribeHook(collector.IncEventSubResubscribe)
+	eventsubClient.SetReconcileHook(collector.ObserveEventSubReconcile)
+	// a revoked authorization only comes back with a new grant, which shows as
+	// a refreshed token or changed scopes
+	for _, m := range tokenManagers() {
+		m.onAuthorized(eventsubClient.RetryRevokedAuthorizations)
+	}
+	go eventsubClient.RunReconciler(context.Background(), *eventSubReconcileInterval)
+
+	if *eventSubConduitEnabled {
+		err := eventsubClient.EnableConduit(context.Background(), appClient, eventsub.ConduitConfig{
+			ID:         *eventSubConduitID,
+			ShardCount: *eventSubConduitShardCount,
+			ShardID:    *eventSubConduitShardID,
+			ClientID:   *twitchClientID,
+			HTTPClient: newInstrumentedHTTPClient("eventsub"),
+		})
+		if err != nil {
+			logger.Error("Error setting up the eventsub conduit; disabling eventsub", "err", err)
+			return nil
+		}
+		eventsubClient.SetConduitStatusHook(collector.SetEventSubConduitShards)
+		go eventsubClient.RunConduitStatus(context.Background())
+	}
+
+	if *eventSubTransport == eventsub.TransportWebSocket {
+		go eventsubClient.Run(context.Background())
+	} else {
+		eventsubClient.SetSignatureFailureHook(func(reason string) {
+			collector.IncEventSubSignatureFail(reason)
+		})
+		// expose the eventsub endpoint
+		http.HandleFunc("/eventsub", eventsubClient.Handler())
+	}
+
+	return eventsubClient
+}
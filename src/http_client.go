@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/webgrip/twitch_exporter/collector"
+	"github.com/webgrip/twitch_exporter/internal/ratelimit"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 60 * time.Second
+)
+
+// rateLimits holds the rate-limit buckets of every client. Twitch counts
+// points per token, so clients sharing a token, such as the Helix and EventSub
+// clients using the app token, share a bucket.
+var rateLimits = sync.OnceValue(func() *ratelimit.Registry {
+	return ratelimit.NewRegistry(*apiRateLimitReserve)
+})
+
+// instrumentedHTTPClient records API metrics, paces requests against the
+// Helix rate-limit bucket and retries idempotent requests on 429 and 5xx.
+type instrumentedHTTPClient struct {
+	api        string
+	inner      *http.Client
+	maxRetries int
+}
+
+func newInstrumentedHTTPClient(api string) helix.HTTPClient {
+	return &instrumentedHTTPClient{
+		api: api,
+		inner: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		maxRetries: *apiMaxRetries,
+	}
+}
+
+// bucketKey identifies the rate-limit bucket a request draws from: the API
+// host and a digest of the token it is sent with.
+func bucketKey(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return req.URL.Host
+	}
+	sum := sha256.Sum256([]byte(auth))
+	return req.URL.Host + "/" + hex.EncodeToString(sum[:8])
+}
+
+// helixClient records how a Helix client was created, so copies bound to
+// another context can be derived from it.
+type helixClient struct {
+	httpClient helix.HTTPClient
+	// onRefreshed is the client's own callback for tokens it refreshed
+	// after a 401.
+	onRefreshed func(accessToken, refreshToken string)
+}
+
+var helixClients sync.Map // *helix.Client -> helixClient
+
+func registerHelixClient(client *helix.Client, httpClient helix.HTTPClient, onRefreshed func(accessToken, refreshToken string)) {
+	helixClients.Store(client, helixClient{httpClient: httpClient, onRefreshed: onRefreshed})
+}
+
+// bindHelixContext returns a copy of client whose requests carry ctx. helix
+// sends every request with the context the client was created with, so
+// collectors use the copy to stop rate-limit waits and retries at their
+// deadline. Tokens the copy refreshes are handed back to client.
+func bindHelixContext(ctx context.Context, client *helix.Client) *helix.Client {
+	v, ok := helixClients.Load(client)
+	if !ok {
+		return client
+	}
+	hc := v.(helixClient)
+	bound, err := helix.NewClientWithContext(ctx, &helix.Options{
+		ClientID:        *twitchClientID,
+		ClientSecret:    *twitchClientSecret,
+		AppAccessToken:  client.GetAppAccessToken(),
+		UserAccessToken: client.GetUserAccessToken(),
+		RefreshToken:    client.GetRefreshToken(),
+		HTTPClient:      hc.httpClient,
+	})
+	if err != nil {
+		return client
+	}
+	bound.OnUserAccessTokenRefreshed(func(accessToken, refreshToken string) {
+		client.SetUserAccessToken(accessToken)
+		client.SetRefreshToken(refreshToken)
+		if hc.onRefreshed != nil {
+			hc.onRefreshed(accessToken, refreshToken)
+		}
+	})
+	return bound
+}
+
+func (c *instrumentedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	endpoint := endpointLabel(req.URL.Path)
+	bucket := rateLimits().Bucket(bucketKey(req))
+	// bindHelixContext gives Helix requests the collector's context, so
+	// the waits below end with the scrape
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		waited, err := bucket.Take(ctx)
+		collector.ObserveAPIQueueWait(c.api, waited)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := c.inner.Do(req)
+		collector.ObserveAPIRequestDuration(c.api, endpoint, time.Since(start))
+		if err != nil {
+			if kind := transportErrorKind(err); kind != "" {
+				collector.IncAPITransportError(c.api, endpoint, kind)
+			}
+			return nil, err
+		}
+		collector.ObserveAPIResponse(c.api, endpoint, resp.StatusCode, resp.Header)
+		bucket.Update(resp.Header)
+
+		reason := retryReason(resp.StatusCode)
+		if reason == "" || !idempotent(req) || attempt >= c.maxRetries {
+			return resp, nil
+		}
+
+		delay := ratelimit.Backoff(attempt, retryBaseDelay, retryMaxDelay)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// the bucket is empty until it resets; jitter spreads the
+			// retries of concurrent collectors
+			if reset := ratelimit.ResetDelay(resp.Header, time.Now()); reset > 0 {
+				delay = min(reset+ratelimit.Backoff(0, retryBaseDelay, retryMaxDelay), retryMaxDelay)
+			}
+		}
+
+		// drain the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+		collector.IncAPIRetry(c.api, endpoint, reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// transportErrorKind classifies a failed round trip. Cancellations are not
+// transport errors (the scrape went away) and return "".
+func transportErrorKind(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		recordErr  tls.RecordHeaderError
+		verifyErr  *tls.CertificateVerificationError
+		unknownErr x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ""
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &recordErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownErr), errors.As(err, &hostErr):
+		return "tls"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	default:
+		return "other"
+	}
+}
+
+func retryReason(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case status >= 500:
+		return "server_error"
+	default:
+		return ""
+	}
+}
+
+// idempotent reports whether req can safely be sent again as is.
+func idempotent(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+func endpointLabel(path string) string {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return "/"
+	}
+	// Keep it bounded: paths are stable, IDs are in query params for Helix.
+	return path
+}
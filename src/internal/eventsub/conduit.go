@@ -0,0 +1,371 @@
+package eventsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// TransportConduit is the subscription transport used when the client owns a
+// conduit shard.
+const TransportConduit = "conduit"
+
+// conduitStatusInterval is how often the shard status is polled.
+const conduitStatusInterval = time.Minute
+
+// ConduitConfig configures conduit mode. helix has no conduit API yet, so the
+// conduit endpoints are called directly with the app access token.
+type ConduitConfig struct {
+	// ID adopts an existing conduit. When empty, the app's first conduit is
+	// adopted, or one is created.
+	ID string
+	// ShardCount is the minimum number of shards; the conduit is grown to it
+	// but never shrunk, since other replicas may own the upper shards.
+	ShardCount int
+	// ShardID is the shard this replica owns.
+	ShardID int
+
+	ClientID   string
+	HTTPClient helix.HTTPClient
+	// APIBaseURL defaults to helix.DefaultAPIBaseURL.
+	APIBaseURL string
+}
+
+type conduitState struct {
+	cfg       ConduitConfig
+	appClient *helix.Client
+	id        string
+
+	onStatus func(counts map[string]int, own string)
+}
+
+type conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+type conduitShard struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status,omitempty"`
+	Transport shardTransport `json:"transport"`
+}
+
+type shardTransport struct {
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// EnableConduit switches c to conduit mode: the conduit is adopted or created
+// and subscriptions are created against it with appClient. This replica's
+// shard is pointed at c's webhook by RunConduitStatus, or at every new session
+// for the websocket transport.
+func (c *Client) EnableConduit(ctx context.Context, appClient *helix.Client, cfg ConduitConfig) error {
+	if appClient == nil {
+		return errors.New("conduits require an app access token")
+	}
+	if cfg.ShardCount < 1 {
+		cfg.ShardCount = 1
+	}
+	if cfg.ShardID < 0 {
+		return fmt.Errorf("invalid conduit shard id %d", cfg.ShardID)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.APIBaseURL == "" {
+		cfg.APIBaseURL = helix.DefaultAPIBaseURL
+	}
+	st := &conduitState{cfg: cfg, appClient: appClient}
+
+	cd, err := st.adopt(ctx)
+	if err != nil {
+		return err
+	}
+	st.id = cd.ID
+	if cd.ShardCount < cfg.ShardCount {
+		c.logger.Info("growing conduit", "conduit_id", cd.ID, "from", cd.ShardCount, "to", cfg.ShardCount)
+		err := st.do(ctx, http.MethodPatch, "/eventsub/conduits", nil,
+			map[string]any{"id": cd.ID, "shard_count": cfg.ShardCount}, nil)
+		if err != nil {
+			return fmt.Errorf("resizing conduit: %w", err)
+		}
+		cd.ShardCount = cfg.ShardCount
+	}
+	if cfg.ShardID >= cd.ShardCount {
+		return fmt.Errorf("conduit %s has %d shards, shard id %d does not exist", cd.ID, cd.ShardCount, cfg.ShardID)
+	}
+
+	c.conduit = st
+	c.appClient = appClient
+	c.logger.Info("eventsub conduit enabled", "conduit_id", cd.ID, "shard_id", cfg.ShardID, "shard_count", cd.ShardCount)
+	return nil
+}
+
+// SetConduitStatusHook is called after every shard status poll with the
+// number of shards per status and the status of this replica's shard.
+func (c *Client) SetConduitStatusHook(hook func(counts map[string]int, own string)) {
+	if c.conduit != nil {
+		c.conduit.onStatus = hook
+	}
+}
+
+func (st *conduitState) adopt(ctx context.Context) (conduit, error) {
+	var list struct {
+		Data []conduit `json:"data"`
+	}
+	if err := st.do(ctx, http.MethodGet, "/eventsub/conduits", nil, nil, &list); err != nil {
+		return conduit{}, fmt.Errorf("listing conduits: %w", err)
+	}
+	for _, cd := range list.Data {
+		if st.cfg.ID == "" || cd.ID == st.cfg.ID {
+			return cd, nil
+		}
+	}
+	if st.cfg.ID != "" {
+		return conduit{}, fmt.Errorf("conduit %s not found", st.cfg.ID)
+	}
+
+	var created struct {
+		Data []conduit `json:"data"`
+	}
+	err := st.do(ctx, http.MethodPost, "/eventsub/conduits", nil,
+		map[string]any{"shard_count": st.cfg.ShardCount}, &created)
+	if err != nil {
+		return conduit{}, fmt.Errorf("creating conduit: %w", err)
+	}
+	if len(created.Data) == 0 {
+		return conduit{}, errors.New("creating conduit: empty response")
+	}
+	return created.Data[0], nil
+}
+
+// assignShard points this replica's shard at transport.
+func (c *Client) assignShard(ctx context.Context, transport shardTransport) error {
+	st := c.conduit
+	var res struct {
+		Errors []struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+			Code    string `json:"code"`
+		} `json:"errors"`
+	}
+	err := st.do(ctx, http.MethodPatch, "/eventsub/conduits/shards", nil, map[string]any{
+		"conduit_id": st.id,
+		"shards": []conduitShard{{
+			ID:        fmt.Sprint(st.cfg.ShardID),
+			Transport: transport,
+		}},
+	}, &res)
+	if err != nil {
+		return fmt.Errorf("updating conduit shard: %w", err)
+	}
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("updating conduit shard %s: %s (%s)", res.Errors[0].ID, res.Errors[0].Message, res.Errors[0].Code)
+	}
+	c.logger.Info("conduit shard assigned", "conduit_id", st.id, "shard_id", st.cfg.ShardID, "method", transport.Method)
+	return nil
+}
+
+// RunConduitStatus polls the shard status until ctx is done. For the webhook
+// transport it also assigns the shard, and assigns it again when Twitch gave
+// up on the callback. This runs once the /eventsub handler is being served,
+// since Twitch verifies the callback right away.
+func (c *Client) RunConduitStatus(ctx context.Context) {
+	if c.conduit == nil {
+		return
+	}
+	ticker := time.NewTicker(conduitStatusInterval)
+	defer ticker.Stop()
+	assigned := c.ws != nil
+	for {
+		if !assigned {
+			err := c.assignShard(ctx, shardTransport{Method: TransportWebhook, Callback: c.webhookURL, Secret: c.webhookSecret})
+			if err != nil {
+				c.logger.Warn("failed to assign conduit shard", "err", err)
+			}
+			assigned = err == nil
+		}
+		own, err := c.pollShards(ctx)
+		if err != nil {
+			c.logger.Warn("failed to get conduit shard status", "err", err)
+		}
+		if c.ws == nil && (own == "webhook_callback_verification_failed" || own == "notification_failures_exceeded") {
+			assigned = false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) pollShards(ctx context.Context) (string, error) {
+	st := c.conduit
+	counts := map[string]int{}
+	own := ""
+	after := ""
+	for {
+		q := url.Values{"conduit_id": {st.id}}
+		if after != "" {
+			q.Set("after", after)
+		}
+		var page struct {
+			Data       []conduitShard `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := st.do(ctx, http.MethodGet, "/eventsub/conduits/shards", q, nil, &page); err != nil {
+			return "", err
+		}
+		for _, s := range page.Data {
+			counts[s.Status]++
+			if s.ID == fmt.Sprint(st.cfg.ShardID) {
+				own = s.Status
+			}
+		}
+		if page.Pagination.Cursor == "" || len(page.Data) == 0 {
+			break
+		}
+		after = page.Pagination.Cursor
+	}
+	if st.onStatus != nil {
+		st.onStatus(counts, own)
+	}
+	return own, nil
+}
+
+// subscribeConduit creates the subscription against the conduit. All replicas
+// subscribe; Twitch answers 409 for subscriptions that already exist.
+func (c *Client) subscribeConduit(eventType string, version string, condition helix.EventSubCondition) error {
+	if version == "" {
+		version = "1"
+	}
+	c.logger.Info("subscribing to event", "event", eventType, "transport", TransportConduit)
+	var created helix.ManyEventSubSubscriptions
+	err := c.conduit.do(context.Background(), http.MethodPost, "/eventsub/subscriptions", nil, map[string]any{
+		"type":      eventType,
+		"version":   version,
+		"condition": conditionFields(condition),
+		"transport": map[string]string{"method": TransportConduit, "conduit_id": c.conduit.id},
+	}, &created)
+	var herr *helixError
+	if errors.As(err, &herr) && herr.StatusCode == http.StatusConflict {
+		c.logger.Info("subscription already exists", "event", eventType)
+		c.subs.setActive(subKey{eventType: eventType, condition: condition}, true)
+		return nil
+	}
+	if err == nil {
+		c.recordCost(c.appClient, created)
+		c.subs.setActive(subKey{eventType: eventType, condition: condition}, true)
+	}
+	return err
+}
+
+// conditionFields drops the empty fields helix.EventSubCondition would send.
+func conditionFields(condition helix.EventSubCondition) map[string]string {
+	b, _ := json.Marshal(condition)
+	var all map[string]string
+	_ = json.Unmarshal(b, &all)
+	fields := map[string]string{}
+	for k, v := range all {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// listConduitSubscriptions returns the app's conduit subscriptions. helix does
+// not decode conduit_id; an app is expected to use a single conduit.
+func (c *Client) listConduitSubscriptions() ([]helix.EventSubSubscription, error) {
+	return c.listSubscriptions(c.appClient, func(s helix.EventSubSubscription) bool {
+		return s.Transport.Method == TransportConduit
+	})
+}
+
+func (c *Client) unsubscribeConduit(eventType string, condition helix.EventSubCondition) error {
+	subs, err := c.listConduitSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, v := range subs {
+		if v.Type != eventType || v.Condition != condition {
+			continue
+		}
+		res, err := c.appClient.RemoveEventSubSubscription(v.ID)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusNoContent {
+			return errors.Join(errors.New("failed to remove subscription"), errors.New(res.ErrorMessage))
+		}
+		c.cost.remove(v.Cost)
+		c.logger.Info("subscription removed", "event", eventType, "subscription_id", v.ID)
+	}
+	return nil
+}
+
+// helixError is a non-2xx answer from a Helix endpoint called directly.
+type helixError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *helixError) Error() string {
+	return fmt.Sprintf("helix returned status %d: %s", e.StatusCode, e.Message)
+}
+
+func (st *conduitState) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	u := strings.TrimSuffix(st.cfg.APIBaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-Id", st.cfg.ClientID)
+	req.Header.Set("Authorization", "Bearer "+st.appClient.GetAppAccessToken())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := st.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return &helixError{StatusCode: resp.StatusCode, Message: e.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,292 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nicklaw5/helix/v2"
+	"golang.org/x/net/websocket"
+)
+
+// fakeConduits serves the conduit endpoints for the conduits it holds and
+// lists shards in pages of two.
+type fakeConduits struct {
+	mu       sync.Mutex
+	conduits []conduit
+	shards   []conduitShard
+	// assigned are the shards PATCHed, by shard ID.
+	assigned map[string]shardTransport
+	// shardErr is returned for every shard update when set.
+	shardErr string
+}
+
+func newFakeConduits(t *testing.T, conduits ...conduit) (*fakeConduits, ConduitConfig, *helix.Client) {
+	f := &fakeConduits{conduits: conduits, assigned: map[string]shardTransport{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		var body struct {
+			ID         string         `json:"id"`
+			ShardCount int            `json:"shard_count"`
+			Shards     []conduitShard `json:"shards"`
+		}
+		if r.Body != nil {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /eventsub/conduits":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": f.conduits})
+		case "POST /eventsub/conduits":
+			cd := conduit{ID: "c" + strconv.Itoa(len(f.conduits)+1), ShardCount: body.ShardCount}
+			f.conduits = append(f.conduits, cd)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []conduit{cd}})
+		case "PATCH /eventsub/conduits":
+			for i := range f.conduits {
+				if f.conduits[i].ID == body.ID {
+					f.conduits[i].ShardCount = body.ShardCount
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": f.conduits})
+		case "PATCH /eventsub/conduits/shards":
+			if f.shardErr != "" {
+				_ = json.NewEncoder(w).Encode(map[string]any{"errors": []map[string]string{{"id": body.Shards[0].ID, "message": f.shardErr, "code": "invalid_parameter"}}})
+				return
+			}
+			for _, s := range body.Shards {
+				f.assigned[s.ID] = s.Transport
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": body.Shards})
+		case "GET /eventsub/conduits/shards":
+			start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+			end := min(start+2, len(f.shards))
+			cursor := ""
+			if end < len(f.shards) {
+				cursor = strconv.Itoa(end)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data":       f.shards[start:end],
+				"pagination": map[string]string{"cursor": cursor},
+			})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	appClient, err := helix.NewClient(&helix.Options{ClientID: "client", AppAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, ConduitConfig{ClientID: "client", APIBaseURL: srv.URL}, appClient
+}
+
+func TestEnableConduit(t *testing.T) {
+	cases := []struct {
+		name       string
+		conduits   []conduit
+		id         string
+		shardCount int
+		shardID    int
+		wantErr    bool
+		wantID     string
+		// want are the conduits held by Twitch afterwards.
+		want []conduit
+	}{
+		{
+			name:       "conduit is created",
+			shardCount: 2,
+			shardID:    1,
+			wantID:     "c1",
+			want:       []conduit{{ID: "c1", ShardCount: 2}},
+		},
+		{
+			name:     "first conduit is adopted",
+			conduits: []conduit{{ID: "a", ShardCount: 1}, {ID: "b", ShardCount: 1}},
+			wantID:   "a",
+			want:     []conduit{{ID: "a", ShardCount: 1}, {ID: "b", ShardCount: 1}},
+		},
+		{
+			name:     "configured conduit is adopted",
+			conduits: []conduit{{ID: "a", ShardCount: 1}, {ID: "b", ShardCount: 1}},
+			id:       "b",
+			wantID:   "b",
+			want:     []conduit{{ID: "a", ShardCount: 1}, {ID: "b", ShardCount: 1}},
+		},
+		{
+			name:     "configured conduit does not exist",
+			conduits: []conduit{{ID: "a", ShardCount: 1}},
+			id:       "b",
+			wantErr:  true,
+			want:     []conduit{{ID: "a", ShardCount: 1}},
+		},
+		{
+			name:       "smaller conduit is grown",
+			conduits:   []conduit{{ID: "a", ShardCount: 1}},
+			shardCount: 3,
+			shardID:    2,
+			wantID:     "a",
+			want:       []conduit{{ID: "a", ShardCount: 3}},
+		},
+		{
+			name:       "larger conduit is not shrunk",
+			conduits:   []conduit{{ID: "a", ShardCount: 4}},
+			shardCount: 2,
+			shardID:    3,
+			wantID:     "a",
+			want:       []conduit{{ID: "a", ShardCount: 4}},
+		},
+		{
+			name:       "shard beyond the conduit",
+			conduits:   []conduit{{ID: "a", ShardCount: 2}},
+			shardCount: 2,
+			shardID:    2,
+			wantErr:    true,
+			want:       []conduit{{ID: "a", ShardCount: 2}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, cfg, appClient := newFakeConduits(t, tc.conduits...)
+			cfg.ID, cfg.ShardCount, cfg.ShardID = tc.id, tc.shardCount, tc.shardID
+			c, err := NewWebSocket("ws://unused", discardLogger, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.EnableConduit(context.Background(), appClient, cfg)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("EnableConduit error = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				if c.conduit != nil {
+					t.Error("conduit mode enabled despite the error")
+				}
+			} else if c.conduit == nil || c.conduit.id != tc.wantID {
+				t.Errorf("conduit = %+v, want %s", c.conduit, tc.wantID)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if len(fake.conduits) != len(tc.want) {
+				t.Fatalf("conduits = %+v, want %+v", fake.conduits, tc.want)
+			}
+			for i := range tc.want {
+				if fake.conduits[i] != tc.want[i] {
+					t.Errorf("conduits = %+v, want %+v", fake.conduits, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnableConduitRejectsNegativeShard(t *testing.T) {
+	_, cfg, appClient := newFakeConduits(t)
+	cfg.ShardID = -1
+	c, err := NewWebSocket("ws://unused", discardLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableConduit(context.Background(), appClient, cfg); err == nil {
+		t.Error("EnableConduit accepted shard -1")
+	}
+}
+
+func TestAssignShard(t *testing.T) {
+	fake, cfg, appClient := newFakeConduits(t, conduit{ID: "a", ShardCount: 3})
+	cfg.ShardCount, cfg.ShardID = 3, 2
+	c, err := NewWebSocket("ws://unused", discardLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableConduit(context.Background(), appClient, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := shardTransport{Method: TransportWebSocket, SessionID: "session"}
+	if err := c.assignShard(context.Background(), transport); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	want := map[string]shardTransport{"2": transport}
+	if !maps.Equal(fake.assigned, want) {
+		t.Errorf("assigned shards = %+v, want only shard 2: %+v", fake.assigned, want)
+	}
+	fake.shardErr = "session does not exist"
+	fake.mu.Unlock()
+
+	if err := c.assignShard(context.Background(), transport); err == nil {
+		t.Error("assignShard ignored the per-shard error")
+	}
+}
+
+func TestPollShards(t *testing.T) {
+	fake, cfg, appClient := newFakeConduits(t, conduit{ID: "a", ShardCount: 5})
+	fake.shards = []conduitShard{
+		{ID: "0", Status: "enabled"},
+		{ID: "1", Status: "enabled"},
+		{ID: "2", Status: "websocket_disconnected"},
+		{ID: "3", Status: "enabled"},
+		{ID: "4", Status: "webhook_callback_verification_pending"},
+	}
+	cfg.ShardCount, cfg.ShardID = 5, 2
+	c, err := NewWebSocket("ws://unused", discardLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableConduit(context.Background(), appClient, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var counts map[string]int
+	c.SetConduitStatusHook(func(c map[string]int, own string) { counts = c })
+
+	own, err := c.pollShards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own != "websocket_disconnected" {
+		t.Errorf("own shard status = %q, want websocket_disconnected", own)
+	}
+	want := map[string]int{"enabled": 3, "websocket_disconnected": 1, "webhook_callback_verification_pending": 1}
+	if !maps.Equal(counts, want) {
+		t.Errorf("shard counts = %v, want %v", counts, want)
+	}
+}
+
+func TestWebSocketWelcomeAssignsShard(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := wsStandIn(t, map[string]func(*websocket.Conn){
+		"/ws": func(ws *websocket.Conn) {
+			welcome(t, ws, "session-1", 10)
+			<-release
+		},
+	})
+	fake, cfg, appClient := newFakeConduits(t, conduit{ID: "a", ShardCount: 2})
+	cfg.ShardCount, cfg.ShardID = 2, 1
+	c, err := NewWebSocket(wsURL(srv, "/ws"), discardLogger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EnableConduit(context.Background(), appClient, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	runSessionAsync(t, c)
+	eventually(t, "the shard assignment", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.assigned) > 0
+	})
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	want := map[string]shardTransport{"1": {Method: TransportWebSocket, SessionID: "session-1"}}
+	if !maps.Equal(fake.assigned, want) {
+		t.Errorf("assigned shards = %+v, want %+v", fake.assigned, want)
+	}
+}
@@ -0,0 +1,86 @@
+package eventsub
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// costCache holds the subscription cost Twitch reported last. Listing and
+// creating subscriptions report it, removals subtract the removed cost, so
+// reading it never calls Helix.
+type costCache struct {
+	mu    sync.Mutex
+	known bool
+	total int
+	limit int
+}
+
+func (c *costCache) set(total int, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.known, c.total, c.limit = true, total, limit
+}
+
+func (c *costCache) remove(cost int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.known {
+		c.total = max(c.total-cost, 0)
+	}
+}
+
+// costClient is the client whose subscriptions count against the cost of this
+// client's transport.
+func (c *Client) costClient() *helix.Client {
+	if c.ws != nil && c.conduit == nil {
+		return c.userClient
+	}
+	return c.appClient
+}
+
+// recordCost caches the cost reported in a response of client.
+func (c *Client) recordCost(client *helix.Client, data helix.ManyEventSubSubscriptions) {
+	if client == nil || client != c.costClient() || data.MaxTotalCost == 0 {
+		return
+	}
+	c.cost.set(data.TotalCost, data.MaxTotalCost)
+}
+
+// SubscriptionCost returns the total cost of the subscriptions of this
+// client's transport and the maximum Twitch allows, as last reported by
+// Twitch. ok is false until Twitch reported them; see RefreshSubscriptionCost.
+// Subscriptions to public topics of broadcasters that have not authorized the
+// app cost 1 each.
+func (c *Client) SubscriptionCost() (total int, limit int, ok bool) {
+	c.cost.mu.Lock()
+	defer c.cost.mu.Unlock()
+	return c.cost.total, c.cost.limit, c.cost.known
+}
+
+// RefreshSubscriptionCost asks Twitch for the current subscription cost and
+// caches it.
+func (c *Client) RefreshSubscriptionCost() (total int, limit int, err error) {
+	client := c.costClient()
+	if client == nil {
+		return 0, 0, errors.New("helix client not configured")
+	}
+	res, err := client.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{})
+	if err != nil {
+		return 0, 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, 0, errors.Join(errors.New("failed to list subscriptions"), errors.New(res.ErrorMessage))
+	}
+	c.recordCost(client, res.Data)
+	return res.Data.TotalCost, res.Data.MaxTotalCost, nil
+}
+
+// Subscribed reports whether a collector already asked for the subscription.
+// Asking for it again creates nothing and costs nothing.
+func (c *Client) Subscribed(eventType string, condition helix.EventSubCondition) bool {
+	_, ok := c.subs.get(subKey{eventType: eventType, condition: condition})
+	return ok
+}
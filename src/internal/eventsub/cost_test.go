@@ -0,0 +1,149 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+func TestSubscriptionCostCache(t *testing.T) {
+	var lists atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			lists.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data":           []helix.EventSubSubscription{{ID: "a", Type: "stream.online", Status: "enabled", Cost: 1}},
+				"total_cost":     4,
+				"max_total_cost": 10,
+			})
+		case http.MethodPost:
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []helix.EventSubSubscription{{ID: "b", Cost: 1}}, "total_cost": 5, "max_total_cost": 10})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	userClient, err := helix.NewClient(&helix.Options{ClientID: "client", UserAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewWebSocket("ws://unused", discardLogger, userClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(step string, wantTotal, wantLimit int, wantOK bool) {
+		t.Helper()
+		total, limit, ok := c.SubscriptionCost()
+		if total != wantTotal || limit != wantLimit || ok != wantOK {
+			t.Errorf("%s: cost = %d/%d ok=%v, want %d/%d ok=%v", step, total, limit, ok, wantTotal, wantLimit, wantOK)
+		}
+	}
+
+	check("before any response", 0, 0, false)
+	if _, _, err := c.RefreshSubscriptionCost(); err != nil {
+		t.Fatal(err)
+	}
+	check("after refresh", 4, 10, true)
+
+	c.setSession("session")
+	if err := c.SubscribeUser("stream.offline", "1", helix.EventSubCondition{BroadcasterUserID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	check("after create", 5, 10, true)
+
+	if err := c.removeSubscription(helix.EventSubSubscription{ID: "b", Cost: 1}); err != nil {
+		t.Fatal(err)
+	}
+	check("after remove", 4, 10, true)
+
+	for range 3 {
+		c.SubscriptionCost()
+	}
+	if n := lists.Load(); n != 1 {
+		t.Errorf("listed subscriptions %d times, want once", n)
+	}
+
+	if !c.Subscribed("stream.offline", helix.EventSubCondition{BroadcasterUserID: "1"}) {
+		t.Error("Subscribed = false for a requested subscription")
+	}
+	if c.Subscribed("stream.online", helix.EventSubCondition{BroadcasterUserID: "1"}) {
+		t.Error("Subscribed = true for a subscription nobody asked for")
+	}
+}
+
+func TestSubscriptionActive(t *testing.T) {
+	self := helix.EventSubCondition{BroadcasterUserID: "1"}
+	other := helix.EventSubCondition{BroadcasterUserID: "2"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			transport := helix.EventSubTransport{Method: TransportWebSocket, SessionID: "session"}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []helix.EventSubSubscription{
+				{ID: "a", Type: "stream.online", Status: "enabled", Condition: self, Transport: transport},
+				{ID: "b", Type: "stream.online", Status: "enabled", Condition: other, Transport: transport},
+			}})
+		case http.MethodPost:
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []helix.EventSubSubscription{{ID: "c"}}})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	userClient, err := helix.NewClient(&helix.Options{ClientID: "client", UserAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewWebSocket("ws://unused", discardLogger, userClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// subscribed before the session, so only the reconciler sees it
+	if err := c.SubscribeUser("stream.online", "1", self); err != nil {
+		t.Fatal(err)
+	}
+	c.setSession("session")
+
+	check := func(step, eventType string, condition helix.EventSubCondition, want bool) {
+		t.Helper()
+		if got := c.SubscriptionActive(eventType, condition); got != want {
+			t.Errorf("%s: SubscriptionActive(%s, %s) = %v, want %v", step, eventType, condition.BroadcasterUserID, got, want)
+		}
+	}
+
+	check("before reconcile", "stream.online", self, false)
+	if res := c.Reconcile(); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	check("after reconcile", "stream.online", self, true)
+	// the same type for another broadcaster is not ours
+	check("after reconcile", "stream.online", other, false)
+
+	if err := c.SubscribeUser("channel.follow", "2", self); err != nil {
+		t.Fatal(err)
+	}
+	check("after create", "channel.follow", self, true)
+
+	c.handleRevocation(helix.EventSubSubscription{Type: "channel.follow", Status: "authorization_revoked", Condition: self})
+	check("after revocation", "channel.follow", self, false)
+
+	if err := c.Unsubscribe("stream.online", self); err != nil {
+		t.Fatal(err)
+	}
+	check("after unsubscribe", "stream.online", self, false)
+
+	if err := c.SubscribeUser("stream.offline", "1", self); err != nil {
+		t.Fatal(err)
+	}
+	c.setSession("")
+	check("after the session ended", "stream.offline", self, false)
+}
@@ -0,0 +1,54 @@
+package eventsub
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxMessageAge is how old a message may be before it is rejected as a
+	// replay. Twitch recommends 10 minutes.
+	maxMessageAge = 10 * time.Minute
+	// maxTrackedMessages bounds the message ID cache.
+	maxTrackedMessages = 10000
+)
+
+// messageIDCache remembers message IDs for a time window so redelivered
+// messages are only handled once. Messages older than the window are rejected
+// as stale before they get here, so forgetting them is safe.
+type messageIDCache struct {
+	window time.Duration
+	max    int
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []string
+}
+
+func newMessageIDCache(window time.Duration, max int) *messageIDCache {
+	return &messageIDCache{window: window, max: max, seen: map[string]time.Time{}}
+}
+
+// duplicate records id and reports whether it was already seen.
+func (c *messageIDCache) duplicate(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.order) > 0 && now.Sub(c.seen[c.order[0]]) >= c.window {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	// make room only for a new id, so a full cache still recognizes its
+	// oldest entry
+	if len(c.order) >= c.max {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.seen[id] = now
+	c.order = append(c.order, id)
+	return false
+}
@@ -0,0 +1,130 @@
+package eventsub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMessageIDCache(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	type seen struct {
+		id   string
+		at   time.Duration
+		want bool
+	}
+	cases := []struct {
+		name   string
+		window time.Duration
+		max    int
+		steps  []seen
+	}{
+		{
+			name: "first delivery is not a duplicate", window: time.Minute, max: 10,
+			steps: []seen{{"a", 0, false}, {"b", 0, false}},
+		},
+		{
+			name: "redelivery within the window", window: time.Minute, max: 10,
+			steps: []seen{{"a", 0, false}, {"a", 30 * time.Second, true}, {"a", 59 * time.Second, true}},
+		},
+		{
+			name: "forgotten after the window", window: time.Minute, max: 10,
+			steps: []seen{{"a", 0, false}, {"a", time.Minute, false}},
+		},
+		{
+			name: "a redelivery does not extend the window", window: time.Minute, max: 10,
+			steps: []seen{{"a", 0, false}, {"a", 50 * time.Second, true}, {"a", 70 * time.Second, false}},
+		},
+		{
+			name: "oldest evicted at capacity", window: time.Hour, max: 2,
+			steps: []seen{{"a", 0, false}, {"b", 1, false}, {"c", 2, false}, {"a", 3, false}, {"c", 4, true}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMessageIDCache(tc.window, tc.max)
+			for i, s := range tc.steps {
+				if got := c.duplicate(s.id, start.Add(s.at)); got != s.want {
+					t.Fatalf("step %d: duplicate(%q) = %v, want %v", i, s.id, got, s.want)
+				}
+			}
+			if len(c.order) > tc.max || len(c.seen) != len(c.order) {
+				t.Errorf("cache holds %d ids in order, %d in the map (max %d)", len(c.order), len(c.seen), tc.max)
+			}
+		})
+	}
+}
+
+func TestIsDuplicateCountsRedeliveries(t *testing.T) {
+	duplicates := 0
+	c := &Client{seen: newMessageIDCache(maxMessageAge, maxTrackedMessages), onDuplicate: func() { duplicates++ }}
+	for _, id := range []string{"a", "", "a", "", "b", "a"} {
+		c.isDuplicate(id)
+	}
+	// messages without an ID are never treated as duplicates
+	if duplicates != 2 {
+		t.Errorf("counted %d duplicates, want 2", duplicates)
+	}
+}
+
+func sign(secret, msgID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msgID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "s3cr3t-s3cr3t"
+	body := []byte(`{"subscription":{"type":"channel.follow"}}`)
+	now := time.Now().UTC()
+	fresh := now.Add(-time.Minute).Format(time.RFC3339Nano)
+	stale := now.Add(-maxMessageAge - time.Minute).Format(time.RFC3339Nano)
+
+	cases := []struct {
+		name       string
+		msgID      string
+		timestamp  string
+		signature  string
+		body       []byte
+		want       bool
+		wantReason string
+	}{
+		{name: "valid", msgID: "m1", timestamp: fresh, signature: sign(secret, "m1", fresh, body), want: true},
+		{name: "slightly in the future", msgID: "m1", timestamp: now.Add(5 * time.Second).Format(time.RFC3339Nano),
+			signature: sign(secret, "m1", now.Add(5*time.Second).Format(time.RFC3339Nano), body), want: true},
+		{name: "missing id", timestamp: fresh, signature: sign(secret, "", fresh, body), wantReason: "missing_headers"},
+		{name: "missing timestamp", msgID: "m1", signature: sign(secret, "m1", "", body), wantReason: "missing_headers"},
+		{name: "missing signature", msgID: "m1", timestamp: fresh, wantReason: "missing_headers"},
+		{name: "no sha256 prefix", msgID: "m1", timestamp: fresh, signature: "md5=abcd", wantReason: "bad_signature"},
+		{name: "not hex", msgID: "m1", timestamp: fresh, signature: "sha256=zz", wantReason: "bad_signature"},
+		{name: "wrong secret", msgID: "m1", timestamp: fresh, signature: sign("other-secret", "m1", fresh, body), wantReason: "bad_signature"},
+		{name: "tampered body", msgID: "m1", timestamp: fresh, signature: sign(secret, "m1", fresh, body), body: []byte(`{}`), wantReason: "bad_signature"},
+		{name: "stale replay", msgID: "m1", timestamp: stale, signature: sign(secret, "m1", stale, body), wantReason: "stale"},
+		{name: "unparsable timestamp", msgID: "m1", timestamp: "yesterday", signature: sign(secret, "m1", "yesterday", body), wantReason: "stale"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reason string
+			c := &Client{webhookSecret: secret, onSignatureFailure: func(r string) { reason = r }}
+			h := http.Header{}
+			h.Set("Twitch-Eventsub-Message-Id", tc.msgID)
+			h.Set("Twitch-Eventsub-Message-Timestamp", tc.timestamp)
+			h.Set("Twitch-Eventsub-Message-Signature", tc.signature)
+			b := body
+			if tc.body != nil {
+				b = tc.body
+			}
+			if got := c.verifySignature(h, b); got != tc.want {
+				t.Errorf("verifySignature = %v, want %v", got, tc.want)
+			}
+			if reason != tc.wantReason {
+				t.Errorf("failure reason = %q, want %q", reason, tc.wantReason)
+			}
+		})
+	}
+}
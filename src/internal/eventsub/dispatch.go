@@ -0,0 +1,34 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// dispatcher fans notifications out to every callback registered for their
+// subscription type.
+type dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(json.RawMessage)
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{handlers: map[string][]func(json.RawMessage){}}
+}
+
+// add registers h and reports whether it is the first handler for event.
+func (d *dispatcher) add(event string, h func(json.RawMessage)) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[event] = append(d.handlers[event], h)
+	return len(d.handlers[event]) == 1
+}
+
+func (d *dispatcher) dispatch(event string, raw json.RawMessage) {
+	d.mu.RLock()
+	handlers := d.handlers[event]
+	d.mu.RUnlock()
+	for _, h := range handlers {
+		h(raw)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/LinneB/twitchwh"
 	"github.com/nicklaw5/helix/v2"
@@ -56,7 +57,16 @@ type Badge struct {
 
 var ErrEventsubClientNotSet = errors.New("eventsub client not set")
 
+const (
+	TransportWebhook   = "webhook"
+	TransportWebSocket = "websocket"
+)
+
+// Client receives EventSub notifications over a webhook (New) or a WebSocket
+// session (NewWebSocket). Either way events are delivered to the callbacks
+// registered with On.
 type Client struct {
+	transport     string
 	webhookURL    string
 	webhookSecret string
 
@@ -64,8 +74,21 @@ type Client struct {
 	userClient *helix.Client
 	logger     *slog.Logger
 	cl         *twitchwh.Client
+	handlers   *dispatcher
+	seen       *messageIDCache
+	subs       *subscriptions
+	cost       costCache
+
+	// websocket transport only, see websocket.go
+	ws *wsState
+	// conduit mode only, see conduit.go
+	conduit *conduitState
 
 	onSignatureFailure func(reason string)
+	onDuplicate        func()
+	onRevocation       func(eventType string, reason string)
+	onResubscribe      func(eventType string, success bool)
+	onReconcile        func(ReconcileResult)
 }
 
 func New(
@@ -75,6 +98,10 @@ func New(
 	userClient *helix.Client,
 ) (*Client, error) {
 	eventsubCl := &Client{
+		transport:     TransportWebhook,
+		handlers:      newDispatcher(),
+		seen:          newMessageIDCache(maxMessageAge, maxTrackedMessages),
+		subs:          newSubscriptions(),
 		appClient:     appClient,
 		userClient:    userClient,
 		logger:        logger,
@@ -99,10 +126,32 @@ func New(
 	return eventsubCl, nil
 }
 
+// Transport returns TransportWebhook or TransportWebSocket.
+func (c *Client) Transport() string {
+	return c.transport
+}
+
 func (c *Client) SetSignatureFailureHook(hook func(reason string)) {
 	c.onSignatureFailure = hook
 }
 
+// SetDuplicateHook is called for every redelivered notification that was
+// dropped.
+func (c *Client) SetDuplicateHook(hook func()) {
+	c.onDuplicate = hook
+}
+
+// isDuplicate reports whether the notification with msgID was handled before.
+func (c *Client) isDuplicate(msgID string) bool {
+	if msgID == "" || !c.seen.duplicate(msgID, time.Now()) {
+		return false
+	}
+	if c.onDuplicate != nil {
+		c.onDuplicate()
+	}
+	return true
+}
+
 func (c *Client) Handler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Verify Twitch signature before delegating to twitchwh.
@@ -119,6 +168,19 @@ func (c *Client) Handler() http.HandlerFunc {
 			return
 		}
 
+		// Twitch redelivers messages it is unsure about; acknowledge them
+		// again without counting them twice. Challenges are exempt, a
+		// retried challenge still needs its answer.
+		msgType := r.Header.Get("Twitch-Eventsub-Message-Type")
+		if msgType != "webhook_callback_verification" &&
+			c.isDuplicate(r.Header.Get("Twitch-Eventsub-Message-Id")) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if msgType == "revocation" {
+			c.handleRevocationBody(body)
+		}
+
 		// Recreate request body for downstream handler.
 		r.Body = io.NopCloser(bytes.NewReader(body))
 		c.cl.Handler(w, r)
@@ -166,28 +228,63 @@ func (c *Client) verifySignature(headers http.Header, body []byte) bool {
 		return false
 	}
 
+	// A valid signature can be replayed; reject messages older than the
+	// window the message ID cache covers.
+	sent, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil || time.Since(sent) > maxMessageAge {
+		if c.onSignatureFailure != nil {
+			c.onSignatureFailure("stale")
+		}
+		return false
+	}
+
 	return true
 }
 
+// On registers callback for event. Several callbacks can be registered for the
+// same event type; each of them receives every notification.
 func (c *Client) On(event string, callback func(eventRaw json.RawMessage)) error {
 	// juuust in case
-	if c.cl == nil {
+	if c.cl == nil && c.ws == nil {
 		c.logger.Warn("eventsub client not set")
 		return ErrEventsubClientNotSet
 	}
 
-	c.cl.On(event, callback)
+	if c.handlers.add(event, callback) && c.cl != nil {
+		// twitchwh keeps a single handler per event, so it gets the fan-out
+		c.cl.On(event, func(raw json.RawMessage) {
+			c.handlers.dispatch(event, raw)
+		})
+	}
 	return nil
 }
 
+// SubscribeApp subscribes with the app token. WebSocket subscriptions can only
+// be created with a user token, so the websocket transport uses that instead.
 func (c *Client) SubscribeApp(eventType string, version string, condition helix.EventSubCondition) error {
+	c.subs.want(subKey{eventType: eventType, condition: condition}, desiredSub{version: version})
+	if c.conduit != nil {
+		return c.subscribeConduit(eventType, version, condition)
+	}
+	if c.ws != nil {
+		return c.subscribeWebSocket(eventType, version, condition)
+	}
 	return c.subscribeWithClient(c.appClient, eventType, version, condition)
 }
 
+// SubscribeUser subscribes with the user token. In conduit mode subscriptions
+// always use the app token; the user's grant to the app authorizes them.
 func (c *Client) SubscribeUser(eventType string, version string, condition helix.EventSubCondition) error {
+	c.subs.want(subKey{eventType: eventType, condition: condition}, desiredSub{version: version, user: true})
+	if c.conduit != nil {
+		return c.subscribeConduit(eventType, version, condition)
+	}
 	if c.userClient == nil {
 		return errors.New("user client not configured")
 	}
+	if c.ws != nil {
+		return c.subscribeWebSocket(eventType, version, condition)
+	}
 	return c.subscribeWithClient(c.userClient, eventType, version, condition)
 }
 
@@ -216,6 +313,7 @@ func (c *Client) subscribeWithClient(client *helix.Client, eventType string, ver
 	if err != nil {
 		return err
 	}
+	c.recordCost(client, subscriptions.Data)
 
 	for _, v := range subscriptions.Data.EventSubSubscriptions {
 		if v.Type != eventType {
@@ -229,6 +327,7 @@ func (c *Client) subscribeWithClient(client *helix.Client, eventType string, ver
 		}
 		if v.Status == "enabled" || v.Status == "webhook_callback_verification_pending" {
 			c.logger.Info("subscription already exists", "event", eventType, "status", v.Status)
+			c.subs.setActive(subKey{eventType: eventType, condition: condition}, true)
 			return nil
 		}
 	}
@@ -255,6 +354,8 @@ func (c *Client) subscribeWithClient(client *helix.Client, eventType string, ver
 		c.logger.Info("failed to create subscription", "res", res)
 		return errors.Join(errors.New("failed to create subscription"), errors.New(res.ErrorMessage))
 	}
+	c.recordCost(client, res.Data)
+	c.subs.setActive(subKey{eventType: eventType, condition: condition}, true)
 
 	c.logger.Info("subscription created", "error", res.Error, "status_code", res.StatusCode, "data", res.Data)
 
@@ -263,13 +364,63 @@ func (c *Client) subscribeWithClient(client *helix.Client, eventType string, ver
 	return nil
 }
 
-func (c *Client) ListSubscriptions() ([]helix.EventSubSubscription, error) {
+// Unsubscribe removes subscriptions for eventType and condition that deliver to
+// this client's webhook. Webhook subscriptions belong to the app, so the app
+// client is used regardless of which token created them.
+func (c *Client) Unsubscribe(eventType string, condition helix.EventSubCondition) error {
+	c.subs.drop(subKey{eventType: eventType, condition: condition})
+	if c.conduit != nil {
+		return c.unsubscribeConduit(eventType, condition)
+	}
+	if c.ws != nil {
+		return c.unsubscribeWebSocket(eventType, condition)
+	}
 	if c.appClient == nil {
-		return nil, errors.New("app client not configured")
+		return errors.New("app client not configured")
+	}
+
+	filterUserID := condition.BroadcasterUserID
+	if filterUserID == "" {
+		filterUserID = condition.UserID
 	}
-	res, err := c.appClient.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{})
+	subscriptions, err := c.appClient.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{
+		Type:   eventType,
+		UserID: filterUserID,
+	})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	c.recordCost(c.appClient, subscriptions.Data)
+
+	for _, v := range subscriptions.Data.EventSubSubscriptions {
+		if v.Type != eventType || v.Transport.Callback != c.webhookURL || v.Condition != condition {
+			continue
+		}
+		res, err := c.appClient.RemoveEventSubSubscription(v.ID)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusNoContent {
+			return errors.Join(errors.New("failed to remove subscription"), errors.New(res.ErrorMessage))
+		}
+		c.cost.remove(v.Cost)
+		c.logger.Info("subscription removed", "event", eventType, "subscription_id", v.ID)
 	}
-	return res.Data.EventSubSubscriptions, nil
+
+	return nil
+}
+
+func (c *Client) ListSubscriptions() ([]helix.EventSubSubscription, error) {
+	if c.conduit != nil {
+		return c.listConduitSubscriptions()
+	}
+	if c.ws != nil {
+		return c.listWebSocketSubscriptions()
+	}
+	if c.appClient == nil {
+		return nil, errors.New("app client not configured")
+	}
+	return c.listSubscriptions(c.appClient, func(s helix.EventSubSubscription) bool {
+		return s.Transport.Method == TransportWebhook && s.Transport.Callback == c.webhookURL
+	})
 }
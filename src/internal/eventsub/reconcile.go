@@ -0,0 +1,200 @@
+package eventsub
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// reconcileStartDelay is the wait before the first pass, so the webhook is
+// being served and startup subscriptions have settled.
+const reconcileStartDelay = time.Minute
+
+// ReconcileResult is the outcome of one reconcile pass.
+type ReconcileResult struct {
+	// Desired is the number of subscriptions the collectors asked for.
+	Desired int
+	// Active is how many of them were enabled (or pending verification)
+	// before the pass.
+	Active  int
+	Created int
+	// Deleted counts failed and stale subscriptions that were removed.
+	Deleted int
+	// Failed counts subscriptions that could not be created or removed.
+	Failed int
+	Err    error
+}
+
+// SetReconcileHook is called after every reconcile pass.
+func (c *Client) SetReconcileHook(hook func(ReconcileResult)) {
+	c.onReconcile = hook
+}
+
+// RunReconciler periodically brings the actual subscriptions in line with the
+// ones the collectors asked for, until ctx is done.
+func (c *Client) RunReconciler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	timer := time.NewTimer(min(reconcileStartDelay, interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		res := c.Reconcile()
+		if res.Err != nil {
+			c.logger.Warn("eventsub reconcile failed", "err", res.Err)
+		} else if res.Created > 0 || res.Deleted > 0 || res.Failed > 0 {
+			c.logger.Info("eventsub subscriptions reconciled", "desired", res.Desired, "active", res.Active,
+				"created", res.Created, "deleted", res.Deleted, "failed", res.Failed)
+		}
+		if c.onReconcile != nil {
+			c.onReconcile(res)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// Reconcile runs one pass: it lists the subscriptions of this client's
+// transport, removes failed ones and stale ones (same callback or session,
+// no longer desired), and creates the desired ones that are missing. Stale
+// conduit subscriptions are left alone, other replicas may want them.
+func (c *Client) Reconcile() ReconcileResult {
+	var res ReconcileResult
+	if c.ws != nil && c.conduit == nil && c.session() == "" {
+		// nothing to reconcile against until a session is welcomed
+		res.Err = errors.New("no websocket session")
+		return res
+	}
+
+	actual, err := c.ListSubscriptions()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	c.subs.mu.Lock()
+	desired := maps.Clone(c.subs.desired)
+	// held subscriptions count as wanted but are recreated elsewhere
+	held := map[subKey]bool{}
+	for k := range desired {
+		if c.subs.held(k) {
+			held[k] = true
+		}
+	}
+	c.subs.mu.Unlock()
+	res.Desired = len(desired)
+
+	healthy := map[subKey]bool{}
+	for _, s := range actual {
+		key := subKey{eventType: s.Type, condition: s.Condition}
+		_, wanted := desired[key]
+		switch {
+		case healthyStatus(s.Status) && wanted:
+			healthy[key] = true
+		case healthyStatus(s.Status) && c.conduit != nil:
+			// stale for this replica, but maybe not for the others
+		default:
+			if err := c.removeSubscription(s); err != nil {
+				c.logger.Warn("failed to remove eventsub subscription", "event", s.Type, "status", s.Status, "err", err)
+				res.Failed++
+				continue
+			}
+			c.logger.Info("removed eventsub subscription", "event", s.Type, "status", s.Status, "wanted", wanted)
+			res.Deleted++
+		}
+	}
+	res.Active = len(healthy)
+
+	for key, d := range desired {
+		if healthy[key] || held[key] {
+			continue
+		}
+		var err error
+		if d.user {
+			err = c.SubscribeUser(key.eventType, d.version, key.condition)
+		} else {
+			err = c.SubscribeApp(key.eventType, d.version, key.condition)
+		}
+		if err != nil {
+			c.logger.Warn("failed to create eventsub subscription", "event", key.eventType, "err", err)
+			res.Failed++
+			continue
+		}
+		healthy[key] = true
+		res.Created++
+	}
+
+	c.subs.mu.Lock()
+	for key := range healthy {
+		if _, ok := c.subs.desired[key]; !ok {
+			// unsubscribed during the pass
+			delete(healthy, key)
+		}
+	}
+	c.subs.active = healthy
+	c.subs.mu.Unlock()
+	return res
+}
+
+// SubscriptionActive reports whether the subscription for eventType and
+// condition is known to be enabled, as of the last reconcile pass and the
+// subscriptions created or revoked since. It does not call Helix.
+func (c *Client) SubscriptionActive(eventType string, condition helix.EventSubCondition) bool {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.subs.active[subKey{eventType: eventType, condition: condition}]
+}
+
+func healthyStatus(status string) bool {
+	return status == "enabled" || status == "webhook_callback_verification_pending"
+}
+
+func (c *Client) removeSubscription(s helix.EventSubSubscription) error {
+	res, err := c.costClient().RemoveEventSubSubscription(s.ID)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		return errors.Join(errors.New("failed to remove subscription"), errors.New(res.ErrorMessage))
+	}
+	if res.StatusCode == http.StatusNoContent {
+		c.cost.remove(s.Cost)
+	}
+	return nil
+}
+
+// listSubscriptions pages through the subscriptions visible to client and
+// keeps the ones keep accepts. The cost of the first page is cached.
+func (c *Client) listSubscriptions(client *helix.Client, keep func(helix.EventSubSubscription) bool) ([]helix.EventSubSubscription, error) {
+	var subs []helix.EventSubSubscription
+	after := ""
+	for {
+		res, err := client.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{After: after})
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.Join(errors.New("failed to list subscriptions"), errors.New(res.ErrorMessage))
+		}
+		if after == "" {
+			c.recordCost(client, res.Data)
+		}
+		for _, s := range res.Data.EventSubSubscriptions {
+			if keep(s) {
+				subs = append(subs, s)
+			}
+		}
+		after = res.Data.Pagination.Cursor
+		if after == "" || len(res.Data.EventSubSubscriptions) == 0 {
+			return subs, nil
+		}
+	}
+}
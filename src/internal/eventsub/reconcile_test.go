@@ -0,0 +1,197 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// fakeEventSub keeps the subscriptions created through it and lists them in
+// pages of pageSize.
+type fakeEventSub struct {
+	mu       sync.Mutex
+	subs     []helix.EventSubSubscription
+	nextID   int
+	pageSize int
+}
+
+func newFakeEventSub(t *testing.T, subs []helix.EventSubSubscription) (*fakeEventSub, *helix.Client) {
+	f := &fakeEventSub{subs: subs, nextID: len(subs), pageSize: 2}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+			end := min(start+f.pageSize, len(f.subs))
+			cursor := ""
+			if end < len(f.subs) {
+				cursor = strconv.Itoa(end)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data":       f.subs[start:end],
+				"pagination": map[string]string{"cursor": cursor},
+			})
+		case http.MethodPost:
+			var sub helix.EventSubSubscription
+			if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+				t.Errorf("decoding subscription: %v", err)
+			}
+			f.nextID++
+			sub.ID = strconv.Itoa(f.nextID)
+			sub.Status = "enabled"
+			f.subs = append(f.subs, sub)
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": []helix.EventSubSubscription{sub}})
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			f.subs = slices.DeleteFunc(f.subs, func(s helix.EventSubSubscription) bool { return s.ID == id })
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := helix.NewClient(&helix.Options{ClientID: "client", UserAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+// state returns "type/broadcaster status" for every subscription, sorted.
+func (f *fakeEventSub) state() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, s := range f.subs {
+		out = append(out, s.Type+"/"+s.Condition.BroadcasterUserID+" "+s.Status)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func wsSub(id, eventType, broadcaster, status, session string) helix.EventSubSubscription {
+	return helix.EventSubSubscription{
+		ID:        id,
+		Type:      eventType,
+		Version:   "1",
+		Status:    status,
+		Condition: helix.EventSubCondition{BroadcasterUserID: broadcaster},
+		Transport: helix.EventSubTransport{Method: TransportWebSocket, SessionID: session},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	cases := []struct {
+		name string
+		// desired are the broadcasters of the stream.online subscriptions
+		// the collectors asked for.
+		desired []string
+		actual  []helix.EventSubSubscription
+		// retrying are desired broadcasters a resubscribe loop owns.
+		retrying  []string
+		noSession bool
+		wantErr   bool
+		want      ReconcileResult
+		wantState []string
+	}{
+		{
+			name:      "missing subscription is created",
+			desired:   []string{"1"},
+			want:      ReconcileResult{Desired: 1, Created: 1},
+			wantState: []string{"stream.online/1 enabled"},
+		},
+		{
+			name:      "healthy subscription is kept",
+			desired:   []string{"1"},
+			actual:    []helix.EventSubSubscription{wsSub("a", "stream.online", "1", "enabled", "session")},
+			want:      ReconcileResult{Desired: 1, Active: 1},
+			wantState: []string{"stream.online/1 enabled"},
+		},
+		{
+			name:      "failed subscription is replaced",
+			desired:   []string{"1"},
+			actual:    []helix.EventSubSubscription{wsSub("a", "stream.online", "1", "websocket_failed_ping_pong", "session")},
+			want:      ReconcileResult{Desired: 1, Created: 1, Deleted: 1},
+			wantState: []string{"stream.online/1 enabled"},
+		},
+		{
+			name:      "stale subscription is removed",
+			desired:   []string{"1"},
+			actual:    []helix.EventSubSubscription{wsSub("a", "stream.online", "1", "enabled", "session"), wsSub("b", "stream.online", "2", "enabled", "session")},
+			want:      ReconcileResult{Desired: 1, Active: 1, Deleted: 1},
+			wantState: []string{"stream.online/1 enabled"},
+		},
+		{
+			name:      "other sessions are left alone",
+			actual:    []helix.EventSubSubscription{wsSub("a", "stream.online", "2", "enabled", "old")},
+			wantState: []string{"stream.online/2 enabled"},
+		},
+		{
+			name:    "every page is read",
+			desired: []string{"3"},
+			actual: []helix.EventSubSubscription{
+				wsSub("a", "stream.online", "1", "enabled", "session"),
+				wsSub("b", "stream.online", "2", "enabled", "session"),
+				wsSub("c", "stream.online", "3", "enabled", "session"),
+				wsSub("d", "stream.online", "4", "enabled", "session"),
+				wsSub("e", "stream.online", "5", "enabled", "session"),
+			},
+			want:      ReconcileResult{Desired: 1, Active: 1, Deleted: 4},
+			wantState: []string{"stream.online/3 enabled"},
+		},
+		{
+			name:     "subscription left to a resubscribe loop is not created",
+			desired:  []string{"1"},
+			retrying: []string{"1"},
+			want:     ReconcileResult{Desired: 1},
+		},
+		{
+			name:      "no session",
+			desired:   []string{"1"},
+			noSession: true,
+			wantErr:   true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, userClient := newFakeEventSub(t, tc.actual)
+			c, err := NewWebSocket("ws://unused", discardLogger, userClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// subscribed before the session, so only the reconciler creates them
+			for _, id := range tc.desired {
+				if err := c.SubscribeUser("stream.online", "1", helix.EventSubCondition{BroadcasterUserID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.subs.mu.Lock()
+			for _, id := range tc.retrying {
+				c.subs.retrying[subKey{eventType: "stream.online", condition: helix.EventSubCondition{BroadcasterUserID: id}}] = true
+			}
+			c.subs.mu.Unlock()
+			if !tc.noSession {
+				c.setSession("session")
+			}
+
+			res := c.Reconcile()
+			if (res.Err != nil) != tc.wantErr {
+				t.Fatalf("Reconcile error = %v, want error %v", res.Err, tc.wantErr)
+			}
+			res.Err = nil
+			if res != tc.want {
+				t.Errorf("Reconcile = %+v, want %+v", res, tc.want)
+			}
+			if got := fake.state(); !slices.Equal(got, tc.wantState) {
+				t.Errorf("subscriptions = %v, want %v", got, tc.wantState)
+			}
+		})
+	}
+}
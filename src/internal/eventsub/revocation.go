@@ -0,0 +1,235 @@
+package eventsub
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+const (
+	resubscribeMaxDelay = time.Hour
+	// resubscribeAttempts bounds the retries after notification failures,
+	// about three hours with the backoff; the reconciler takes over after.
+	resubscribeAttempts = 8
+)
+
+var resubscribeBaseDelay = time.Minute // shortened by tests
+
+// desiredSub is a subscription the collectors asked for, remembered so it can
+// be created again after a revocation.
+type desiredSub struct {
+	version string
+	user    bool
+}
+
+type subscriptions struct {
+	mu      sync.Mutex
+	desired map[subKey]desiredSub
+	// retrying holds the subscriptions a resubscribe loop is running for.
+	retrying map[subKey]bool
+	// revoked holds the subscriptions whose authorization was revoked; they
+	// wait for a new grant, see RetryRevokedAuthorizations.
+	revoked map[subKey]bool
+	// active holds the subscriptions known to be enabled: those the last
+	// reconcile pass found healthy or created, and those created since.
+	active map[subKey]bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		desired:  map[subKey]desiredSub{},
+		retrying: map[subKey]bool{},
+		revoked:  map[subKey]bool{},
+		active:   map[subKey]bool{},
+	}
+}
+
+func (s *subscriptions) want(key subKey, d desiredSub) {
+	s.mu.Lock()
+	s.desired[key] = d
+	s.mu.Unlock()
+}
+
+func (s *subscriptions) drop(key subKey) {
+	s.mu.Lock()
+	delete(s.desired, key)
+	delete(s.revoked, key)
+	delete(s.active, key)
+	s.mu.Unlock()
+}
+
+func (s *subscriptions) setActive(key subKey, active bool) {
+	s.mu.Lock()
+	if active {
+		s.active[key] = true
+	} else {
+		delete(s.active, key)
+	}
+	s.mu.Unlock()
+}
+
+func (s *subscriptions) get(key subKey) (desiredSub, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.desired[key]
+	return d, ok
+}
+
+// held reports whether key is left to a resubscribe loop or waits for a new
+// authorization, so the reconciler must not create it. The caller holds mu.
+func (s *subscriptions) held(key subKey) bool {
+	return s.retrying[key] || s.revoked[key]
+}
+
+type revocationPayload struct {
+	Subscription helix.EventSubSubscription `json:"subscription"`
+}
+
+// recoverable reports whether a subscription revoked for reason can come back:
+// a failing endpoint may be reachable again, and a revoked authorization may be
+// granted again (auth login). Deleted users and removed versions stay gone.
+func recoverable(reason string) bool {
+	switch reason {
+	case "notification_failures_exceeded", "authorization_revoked":
+		return true
+	default:
+		return false
+	}
+}
+
+// SetRevocationHook is called for every revoked subscription with its type and
+// the revocation reason (the subscription status).
+func (c *Client) SetRevocationHook(hook func(eventType string, reason string)) {
+	c.onRevocation = hook
+}
+
+// SetResubscribeHook is called after every attempt to recreate a revoked
+// subscription.
+func (c *Client) SetResubscribeHook(hook func(eventType string, success bool)) {
+	c.onResubscribe = hook
+}
+
+func (c *Client) handleRevocationBody(body []byte) {
+	var p revocationPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		c.logger.Warn("failed to decode eventsub revocation", "err", err)
+		return
+	}
+	c.handleRevocation(p.Subscription)
+}
+
+// handleRevocation counts the revocation and, when it can recover, recreates
+// the subscription while it is still wanted: after notification failures with
+// a bounded backoff, after a revoked authorization once a new grant shows up.
+func (c *Client) handleRevocation(sub helix.EventSubSubscription) {
+	reason := sub.Status
+	c.logger.Warn("eventsub subscription revoked", "event", sub.Type, "reason", reason, "subscription_id", sub.ID)
+	if c.onRevocation != nil {
+		c.onRevocation(sub.Type, reason)
+	}
+
+	key := subKey{eventType: sub.Type, condition: sub.Condition}
+	c.subs.setActive(key, false)
+	if !recoverable(reason) {
+		c.subs.drop(key)
+		if c.ws != nil {
+			c.forget(sub.Type, sub.Condition)
+		}
+		return
+	}
+
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	if _, ok := c.subs.desired[key]; !ok || c.subs.held(key) {
+		return
+	}
+	if reason == "authorization_revoked" {
+		// retrying cannot help before the user authorizes the app again
+		c.subs.revoked[key] = true
+		c.logger.Info("eventsub subscription waits for a new authorization", "event", sub.Type)
+		return
+	}
+	c.subs.retrying[key] = true
+	go c.resubscribe(key, resubscribeBaseDelay)
+}
+
+// RetryRevokedAuthorizations recreates, once, the subscriptions revoked with
+// authorization_revoked. It is meant to be called when the token was refreshed
+// or its scopes changed, the only ways a new grant becomes visible. It does not
+// block; subscriptions that still fail wait for the next call.
+func (c *Client) RetryRevokedAuthorizations() {
+	c.subs.mu.Lock()
+	keys := make([]subKey, 0, len(c.subs.revoked))
+	for key := range c.subs.revoked {
+		keys = append(keys, key)
+		c.subs.retrying[key] = true
+	}
+	clear(c.subs.revoked)
+	c.subs.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	go func() {
+		for _, key := range keys {
+			err := c.recreate(key)
+			c.subs.mu.Lock()
+			delete(c.subs.retrying, key)
+			if _, ok := c.subs.desired[key]; ok && err != nil {
+				c.subs.revoked[key] = true
+			}
+			c.subs.mu.Unlock()
+		}
+	}()
+}
+
+func (c *Client) resubscribe(key subKey, delay time.Duration) {
+	defer func() {
+		c.subs.mu.Lock()
+		delete(c.subs.retrying, key)
+		c.subs.mu.Unlock()
+	}()
+
+	for attempt := 1; ; attempt++ {
+		time.Sleep(delay)
+		if _, ok := c.subs.get(key); !ok {
+			// unsubscribed meanwhile
+			return
+		}
+		err := c.recreate(key)
+		if err == nil {
+			return
+		}
+		if attempt == resubscribeAttempts {
+			// still wanted: the next reconcile pass creates it again
+			c.logger.Error("failed to restore eventsub subscription; leaving it to the reconciler", "event", key.eventType, "attempts", attempt, "err", err)
+			return
+		}
+		delay = min(delay*2, resubscribeMaxDelay)
+		c.logger.Warn("failed to restore eventsub subscription", "event", key.eventType, "err", err, "retry_in", delay)
+	}
+}
+
+// recreate makes one attempt to create a revoked subscription that is still
+// wanted, and reports it to the resubscribe hook.
+func (c *Client) recreate(key subKey) error {
+	d, ok := c.subs.get(key)
+	if !ok {
+		return nil
+	}
+	var err error
+	if d.user {
+		err = c.SubscribeUser(key.eventType, d.version, key.condition)
+	} else {
+		err = c.SubscribeApp(key.eventType, d.version, key.condition)
+	}
+	if c.onResubscribe != nil {
+		c.onResubscribe(key.eventType, err == nil)
+	}
+	if err == nil {
+		c.logger.Info("eventsub subscription restored", "event", key.eventType)
+	}
+	return err
+}
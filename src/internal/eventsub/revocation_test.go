@@ -0,0 +1,144 @@
+package eventsub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+func TestHandleRevocation(t *testing.T) {
+	condition := helix.EventSubCondition{BroadcasterUserID: "1"}
+	cases := []struct {
+		name   string
+		reason string
+		// unwanted revokes a subscription the collectors never asked for.
+		unwanted     bool
+		wantRetrying bool
+		wantRevoked  bool
+		wantDesired  bool
+		// wantRestored is whether RetryRevokedAuthorizations creates it again.
+		wantRestored bool
+	}{
+		{name: "authorization revoked", reason: "authorization_revoked", wantRevoked: true, wantDesired: true, wantRestored: true},
+		{name: "notification failures", reason: "notification_failures_exceeded", wantRetrying: true, wantDesired: true},
+		{name: "user removed", reason: "user_removed"},
+		{name: "version removed", reason: "version_removed"},
+		{name: "not wanted", reason: "authorization_revoked", unwanted: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, userClient := newFakeHelix(t)
+			c, err := NewWebSocket("ws://unused", discardLogger, userClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				mu       sync.Mutex
+				restored []bool
+			)
+			c.SetResubscribeHook(func(_ string, success bool) {
+				mu.Lock()
+				restored = append(restored, success)
+				mu.Unlock()
+			})
+			if !tc.unwanted {
+				if err := c.SubscribeUser("channel.follow", "2", condition); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// subscriptions are only created once a session is welcomed
+			c.setSession("session")
+
+			c.handleRevocation(helix.EventSubSubscription{Type: "channel.follow", Status: tc.reason, Condition: condition})
+
+			key := subKey{eventType: "channel.follow", condition: condition}
+			c.subs.mu.Lock()
+			retrying, revoked := c.subs.retrying[key], c.subs.revoked[key]
+			_, desired := c.subs.desired[key]
+			c.subs.mu.Unlock()
+			if retrying != tc.wantRetrying || revoked != tc.wantRevoked || desired != tc.wantDesired {
+				t.Fatalf("retrying=%v revoked=%v desired=%v, want %v %v %v",
+					retrying, revoked, desired, tc.wantRetrying, tc.wantRevoked, tc.wantDesired)
+			}
+			if n := len(fake.subscriptions()); n != 0 {
+				t.Fatalf("created %d subscriptions before a new authorization", n)
+			}
+
+			c.RetryRevokedAuthorizations()
+			if tc.wantRestored {
+				eventually(t, "the subscription to be restored", func() bool { return len(fake.subscriptions()) == 1 })
+				eventually(t, "the retry to finish", func() bool {
+					c.subs.mu.Lock()
+					defer c.subs.mu.Unlock()
+					return !c.subs.held(key)
+				})
+				mu.Lock()
+				defer mu.Unlock()
+				if len(restored) != 1 || !restored[0] {
+					t.Errorf("resubscribe hook got %v, want one success", restored)
+				}
+				return
+			}
+			if n := len(fake.subscriptions()); n != 0 {
+				t.Errorf("RetryRevokedAuthorizations created %d subscriptions", n)
+			}
+		})
+	}
+}
+
+func TestResubscribeHandsOverToReconciler(t *testing.T) {
+	prev := resubscribeBaseDelay
+	resubscribeBaseDelay = time.Millisecond
+	t.Cleanup(func() { resubscribeBaseDelay = prev })
+
+	var creates atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			creates.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"Bad Request","status":400,"message":"endpoint unreachable"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[],"total_cost":0,"max_total_cost":10}`))
+	}))
+	defer srv.Close()
+	userClient, err := helix.NewClient(&helix.Options{ClientID: "client", UserAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewWebSocket("ws://unused", discardLogger, userClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition := helix.EventSubCondition{BroadcasterUserID: "1"}
+	// subscribed before the session, so nothing is created yet
+	if err := c.SubscribeUser("channel.follow", "2", condition); err != nil {
+		t.Fatal(err)
+	}
+	c.setSession("session")
+
+	c.handleRevocation(helix.EventSubSubscription{Type: "channel.follow", Status: "notification_failures_exceeded", Condition: condition})
+	key := subKey{eventType: "channel.follow", condition: condition}
+	eventually(t, "the resubscribe attempts to run out", func() bool {
+		c.subs.mu.Lock()
+		defer c.subs.mu.Unlock()
+		return !c.subs.retrying[key]
+	})
+	if n := creates.Load(); n != resubscribeAttempts {
+		t.Errorf("resubscribe made %d attempts, want %d", n, resubscribeAttempts)
+	}
+	if !c.Subscribed("channel.follow", condition) {
+		t.Fatal("subscription is no longer desired after the attempts ran out")
+	}
+
+	res := c.Reconcile()
+	if res.Desired != 1 || res.Failed != 1 || creates.Load() != resubscribeAttempts+1 {
+		t.Errorf("reconcile = %+v after %d creates, want it to try the subscription again", res, creates.Load())
+	}
+}
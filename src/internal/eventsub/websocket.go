@@ -0,0 +1,462 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultWebSocketURL is the Twitch EventSub WebSocket endpoint. Point the
+// client at a local stand-in (e.g. `twitch event websocket start-server`) to
+// exercise the transport without Twitch.
+const DefaultWebSocketURL = "wss://eventsub.wss.twitch.tv/ws"
+
+var (
+	// welcomeTimeout bounds the wait for session_welcome after connecting.
+	welcomeTimeout = 10 * time.Second
+	// keepaliveGrace is added to the keepalive timeout Twitch announces
+	// before the connection is considered dead.
+	keepaliveGrace = 5 * time.Second
+)
+
+const (
+	// maxMessageBytes caps a single WebSocket message.
+	maxMessageBytes = 1 << 20
+
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 2 * time.Minute
+)
+
+type subKey struct {
+	eventType string
+	condition helix.EventSubCondition
+}
+
+// wsState is the WebSocket session. Subscriptions are remembered so they can
+// be created again whenever a new session starts; a session_reconnect keeps
+// them.
+type wsState struct {
+	url string
+
+	mu        sync.Mutex
+	sessionID string
+	subs      map[subKey]string // version
+}
+
+type wsMessage struct {
+	Metadata struct {
+		MessageID        string `json:"message_id"`
+		MessageType      string `json:"message_type"`
+		MessageTimestamp string `json:"message_timestamp"`
+		SubscriptionType string `json:"subscription_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session *struct {
+			ID                      string `json:"id"`
+			Status                  string `json:"status"`
+			KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+			ReconnectURL            string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription *helix.EventSubSubscription `json:"subscription"`
+		Event        json.RawMessage             `json:"event"`
+	} `json:"payload"`
+}
+
+// NewWebSocket returns a client that receives notifications over an EventSub
+// WebSocket session. Subscriptions are created with userClient, since Twitch
+// only accepts user tokens for the websocket transport; userClient may only be
+// nil when the session serves a conduit shard (see EnableConduit). Call Run
+// to connect.
+func NewWebSocket(url string, logger *slog.Logger, userClient *helix.Client) (*Client, error) {
+	if url == "" {
+		url = DefaultWebSocketURL
+	}
+	return &Client{
+		transport:  TransportWebSocket,
+		handlers:   newDispatcher(),
+		seen:       newMessageIDCache(maxMessageAge, maxTrackedMessages),
+		subs:       newSubscriptions(),
+		userClient: userClient,
+		logger:     logger,
+		ws: &wsState{
+			url:  url,
+			subs: map[subKey]string{},
+		},
+	}, nil
+}
+
+// Run keeps a WebSocket session open until ctx is done, reconnecting with
+// backoff when the connection drops or keepalives stop arriving.
+func (c *Client) Run(ctx context.Context) {
+	attempt := 0
+	for {
+		err := c.runSession(ctx)
+		if c.session() != "" {
+			// the session was up, so this is a fresh outage
+			attempt = 0
+		}
+		c.setSession("")
+		if ctx.Err() != nil {
+			return
+		}
+
+		ceiling := min(reconnectBaseDelay<<min(attempt, 10), reconnectMaxDelay)
+		attempt++
+		delay := ceiling/2 + rand.N(ceiling/2+1)
+		c.logger.Warn("eventsub websocket disconnected; reconnecting", "err", err, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// wsFrame is a message, or the read error, of one connection of a session.
+type wsFrame struct {
+	conn *websocket.Conn
+	data []byte
+	err  error
+}
+
+// runSession serves one session, following session_reconnect to new URLs,
+// until the connection fails.
+func (c *Client) runSession(ctx context.Context) error {
+	conn, err := dialWebSocket(ctx, c.ws.url)
+	if err != nil {
+		return err
+	}
+
+	frames := make(chan wsFrame)
+	done := make(chan struct{})
+	conns := []*websocket.Conn{}
+	read := func(conn *websocket.Conn) {
+		conns = append(conns, conn)
+		go readWebSocket(conn, frames, done)
+	}
+	defer func() {
+		close(done)
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	// active is the connection of the session. After a session_reconnect,
+	// next is the connection it moves to; active is read until next is
+	// welcomed, so nothing Twitch delivers during the handover is lost.
+	// Messages a retired connection read before it was closed still count.
+	var (
+		active   = conn
+		next     *websocket.Conn
+		handover <-chan time.Time
+		retired  = map[*websocket.Conn]bool{}
+	)
+	read(conn)
+
+	timeout := welcomeTimeout
+	keepalive := time.NewTimer(timeout)
+	defer keepalive.Stop()
+
+	for {
+		var f wsFrame
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-keepalive.C:
+			if next != nil {
+				// the old connection may go quiet during the handover
+				retired[active] = true
+				_ = active.Close()
+				active = nil
+				continue
+			}
+			return fmt.Errorf("no eventsub websocket message within %s", timeout)
+		case <-handover:
+			c.logger.Warn("eventsub websocket reconnect got no session_welcome; staying on the current connection")
+			_ = next.Close()
+			next, handover = nil, nil
+			if active == nil {
+				return errors.New("session_reconnect failed")
+			}
+			continue
+		case f = <-frames:
+		}
+
+		switch {
+		case f.conn != active && f.conn != next:
+			if f.err != nil || !retired[f.conn] {
+				delete(retired, f.conn)
+				continue
+			}
+		case f.err != nil && f.conn == next:
+			c.logger.Warn("eventsub websocket reconnect failed; staying on the current connection", "err", f.err)
+			next, handover = nil, nil
+			if active == nil {
+				return fmt.Errorf("following session_reconnect: %w", f.err)
+			}
+			continue
+		case f.err != nil && next != nil:
+			// Twitch closes the old connection once the new one is up
+			active = nil
+			keepalive.Stop()
+			continue
+		case f.err != nil:
+			return f.err
+		case f.conn == active:
+			keepalive.Reset(timeout)
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(f.data, &msg); err != nil {
+			c.logger.Warn("failed to decode eventsub websocket message", "err", err)
+			continue
+		}
+
+		switch msg.Metadata.MessageType {
+		case "session_welcome":
+			if f.conn != active && f.conn != next {
+				continue
+			}
+			if msg.Payload.Session == nil {
+				return errors.New("session_welcome without session")
+			}
+			// a welcome on next completes a reconnect: the subscriptions
+			// moved over and must not be created again
+			reconnected := f.conn == next
+			if reconnected {
+				if active != nil {
+					retired[active] = true
+					_ = active.Close()
+				}
+				active, next, handover = next, nil, nil
+			}
+			session := msg.Payload.Session
+			if session.KeepaliveTimeoutSeconds > 0 {
+				timeout = time.Duration(session.KeepaliveTimeoutSeconds)*time.Second + keepaliveGrace
+			}
+			keepalive.Reset(timeout)
+			c.setSession(session.ID)
+			c.logger.Info("eventsub websocket session started", "session_id", session.ID, "reconnect", reconnected)
+			switch {
+			case reconnected:
+			case c.conduit != nil:
+				// the shard has to point at the session within seconds
+				go func(sessionID string) {
+					err := c.assignShard(ctx, shardTransport{Method: TransportWebSocket, SessionID: sessionID})
+					if err != nil {
+						c.logger.Warn("failed to assign conduit shard", "err", err)
+					}
+				}(session.ID)
+			default:
+				// Twitch closes sessions without a subscription after a
+				// few seconds, so keep reading while they are created.
+				go c.subscribeAll(session.ID)
+			}
+
+		case "session_keepalive":
+			// the keepalive timer was already reset
+
+		case "session_reconnect":
+			if f.conn != active || next != nil {
+				continue
+			}
+			if msg.Payload.Session == nil || msg.Payload.Session.ReconnectURL == "" {
+				return errors.New("session_reconnect without reconnect_url")
+			}
+			c.logger.Info("eventsub websocket reconnect requested")
+			next, err = dialWebSocket(ctx, msg.Payload.Session.ReconnectURL)
+			if err != nil {
+				return fmt.Errorf("following session_reconnect: %w", err)
+			}
+			read(next)
+			handover = time.After(welcomeTimeout)
+
+		case "notification":
+			eventType := msg.Metadata.SubscriptionType
+			if eventType == "" && msg.Payload.Subscription != nil {
+				eventType = msg.Payload.Subscription.Type
+			}
+			if c.isDuplicate(msg.Metadata.MessageID) {
+				continue
+			}
+			c.handlers.dispatch(eventType, msg.Payload.Event)
+
+		case "revocation":
+			if c.isDuplicate(msg.Metadata.MessageID) {
+				continue
+			}
+			if s := msg.Payload.Subscription; s != nil {
+				c.handleRevocation(*s)
+			}
+
+		default:
+			c.logger.Debug("ignoring eventsub websocket message", "message_type", msg.Metadata.MessageType)
+		}
+	}
+}
+
+// readWebSocket passes the messages of conn to frames until reading fails or
+// done is closed.
+func readWebSocket(conn *websocket.Conn, frames chan<- wsFrame, done <-chan struct{}) {
+	for {
+		var data []byte
+		err := websocket.Message.Receive(conn, &data)
+		select {
+		case frames <- wsFrame{conn: conn, data: data, err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func dialWebSocket(ctx context.Context, url string) (*websocket.Conn, error) {
+	cfg, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, welcomeTimeout)
+	defer cancel()
+	conn, err := cfg.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.MaxPayloadBytes = maxMessageBytes
+	return conn, nil
+}
+
+func (c *Client) setSession(id string) {
+	c.ws.mu.Lock()
+	c.ws.sessionID = id
+	c.ws.mu.Unlock()
+	if id == "" && c.conduit == nil {
+		// websocket subscriptions end with their session
+		c.subs.mu.Lock()
+		clear(c.subs.active)
+		c.subs.mu.Unlock()
+	}
+}
+
+func (c *Client) session() string {
+	c.ws.mu.Lock()
+	defer c.ws.mu.Unlock()
+	return c.ws.sessionID
+}
+
+func (c *Client) forget(eventType string, condition helix.EventSubCondition) {
+	c.ws.mu.Lock()
+	delete(c.ws.subs, subKey{eventType: eventType, condition: condition})
+	c.ws.mu.Unlock()
+}
+
+// subscribeWebSocket records the subscription and creates it right away when a
+// session is open; otherwise it is created once the session is welcomed.
+func (c *Client) subscribeWebSocket(eventType string, version string, condition helix.EventSubCondition) error {
+	if c.userClient == nil {
+		return errors.New("user client not configured")
+	}
+	if version == "" {
+		version = "1"
+	}
+	c.ws.mu.Lock()
+	c.ws.subs[subKey{eventType: eventType, condition: condition}] = version
+	sessionID := c.ws.sessionID
+	c.ws.mu.Unlock()
+
+	if sessionID == "" {
+		return nil
+	}
+	return c.createWebSocketSubscription(sessionID, eventType, version, condition)
+}
+
+func (c *Client) subscribeAll(sessionID string) {
+	c.ws.mu.Lock()
+	subs := make(map[subKey]string, len(c.ws.subs))
+	for k, v := range c.ws.subs {
+		subs[k] = v
+	}
+	c.ws.mu.Unlock()
+
+	for k, version := range subs {
+		if c.session() != sessionID {
+			// the session ended while subscribing; the next one starts over
+			return
+		}
+		if err := c.createWebSocketSubscription(sessionID, k.eventType, version, k.condition); err != nil {
+			c.logger.Warn("failed to subscribe to eventsub", "event", k.eventType, "err", err)
+		}
+	}
+}
+
+func (c *Client) createWebSocketSubscription(sessionID string, eventType string, version string, condition helix.EventSubCondition) error {
+	c.logger.Info("subscribing to event", "event", eventType, "transport", TransportWebSocket)
+	res, err := c.userClient.CreateEventSubSubscription(&helix.EventSubSubscription{
+		Type:      eventType,
+		Version:   version,
+		Condition: condition,
+		Transport: helix.EventSubTransport{
+			Method:    TransportWebSocket,
+			SessionID: sessionID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusAccepted {
+		return errors.Join(errors.New("failed to create subscription"), errors.New(res.ErrorMessage))
+	}
+	c.recordCost(c.userClient, res.Data)
+	c.subs.setActive(subKey{eventType: eventType, condition: condition}, true)
+	return nil
+}
+
+func (c *Client) unsubscribeWebSocket(eventType string, condition helix.EventSubCondition) error {
+	c.forget(eventType, condition)
+	sessionID := c.session()
+	if sessionID == "" {
+		return nil
+	}
+
+	subs, err := c.listWebSocketSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, v := range subs {
+		if v.Type != eventType || v.Condition != condition || v.Transport.SessionID != sessionID {
+			continue
+		}
+		res, err := c.userClient.RemoveEventSubSubscription(v.ID)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusNoContent {
+			return errors.Join(errors.New("failed to remove subscription"), errors.New(res.ErrorMessage))
+		}
+		c.cost.remove(v.Cost)
+		c.logger.Info("subscription removed", "event", eventType, "subscription_id", v.ID)
+	}
+	return nil
+}
+
+// listWebSocketSubscriptions returns the subscriptions of the current session.
+// Those of earlier sessions are disconnected and cleaned up by Twitch.
+func (c *Client) listWebSocketSubscriptions() ([]helix.EventSubSubscription, error) {
+	if c.userClient == nil {
+		return nil, errors.New("user client not configured")
+	}
+	sessionID := c.session()
+	return c.listSubscriptions(c.userClient, func(s helix.EventSubSubscription) bool {
+		return s.Transport.Method == TransportWebSocket && s.Transport.SessionID == sessionID
+	})
+}
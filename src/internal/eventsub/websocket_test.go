@@ -0,0 +1,389 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"golang.org/x/net/websocket"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// wsStandIn serves EventSub WebSocket sessions; every path runs its own
+// script against the connection.
+func wsStandIn(t *testing.T, scripts map[string]func(ws *websocket.Conn)) *httptest.Server {
+	mux := http.NewServeMux()
+	for path, script := range scripts {
+		mux.Handle(path, websocket.Handler(script))
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+func sendWS(t *testing.T, ws *websocket.Conn, messageType string, payload map[string]any) {
+	msg := map[string]any{
+		"metadata": map[string]any{
+			"message_id":        messageType + "-" + time.Now().Format(time.RFC3339Nano),
+			"message_type":      messageType,
+			"message_timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		"payload": payload,
+	}
+	if messageType == "notification" {
+		msg["metadata"].(map[string]any)["subscription_type"] = "channel.follow"
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := websocket.Message.Send(ws, string(b)); err != nil {
+		t.Errorf("sending %s: %v", messageType, err)
+	}
+}
+
+func welcome(t *testing.T, ws *websocket.Conn, sessionID string, keepalive int) {
+	sendWS(t, ws, "session_welcome", map[string]any{"session": map[string]any{
+		"id": sessionID, "status": "connected", "keepalive_timeout_seconds": keepalive,
+	}})
+}
+
+func notify(t *testing.T, ws *websocket.Conn, userLogin string) {
+	sendWS(t, ws, "notification", map[string]any{
+		"subscription": map[string]any{"type": "channel.follow"},
+		"event":        map[string]any{"user_login": userLogin},
+	})
+}
+
+// waitClosed blocks until the client closes ws or the test gives up.
+func waitClosed(ws *websocket.Conn, timeout time.Duration) bool {
+	_ = ws.SetReadDeadline(time.Now().Add(timeout))
+	var discard []byte
+	for {
+		if err := websocket.Message.Receive(ws, &discard); err != nil {
+			return !strings.Contains(err.Error(), "timeout")
+		}
+	}
+}
+
+// fakeHelix records the subscriptions created through it.
+type fakeHelix struct {
+	mu      sync.Mutex
+	created []helix.EventSubSubscription
+}
+
+func newFakeHelix(t *testing.T) (*fakeHelix, *helix.Client) {
+	f := &fakeHelix{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/eventsub/subscriptions" {
+			http.NotFound(w, r)
+			return
+		}
+		var sub helix.EventSubSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			t.Errorf("decoding subscription: %v", err)
+		}
+		f.mu.Lock()
+		f.created = append(f.created, sub)
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": []helix.EventSubSubscription{sub}})
+	}))
+	t.Cleanup(srv.Close)
+	client, err := helix.NewClient(&helix.Options{ClientID: "client", UserAccessToken: "token", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+func (f *fakeHelix) subscriptions() []helix.EventSubSubscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]helix.EventSubSubscription(nil), f.created...)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// runSessionAsync runs c.runSession until the test ends and returns its
+// result channel.
+func runSessionAsync(t *testing.T, c *Client) chan error {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errs <- c.runSession(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Error("runSession did not stop after cancel")
+		}
+	})
+	return errs
+}
+
+func setTimeouts(t *testing.T, welcome, grace time.Duration) {
+	prevWelcome, prevGrace := welcomeTimeout, keepaliveGrace
+	welcomeTimeout, keepaliveGrace = welcome, grace
+	t.Cleanup(func() { welcomeTimeout, keepaliveGrace = prevWelcome, prevGrace })
+}
+
+func TestWebSocketWelcomeSubscribesWithSessionID(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := wsStandIn(t, map[string]func(*websocket.Conn){
+		"/ws": func(ws *websocket.Conn) {
+			welcome(t, ws, "session-1", 10)
+			<-release
+		},
+	})
+	fake, userClient := newFakeHelix(t)
+	c, _ := NewWebSocket(wsURL(srv, "/ws"), discardLogger, userClient)
+
+	condition := helix.EventSubCondition{BroadcasterUserID: "1234", ModeratorUserID: "1234"}
+	// before the session exists the subscription is only remembered
+	if err := c.SubscribeUser("channel.follow", "2", condition); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.subscriptions()) != 0 {
+		t.Fatal("subscription created before the session was welcomed")
+	}
+
+	runSessionAsync(t, c)
+	eventually(t, "the subscription", func() bool { return len(fake.subscriptions()) == 1 })
+	if got := c.session(); got != "session-1" {
+		t.Errorf("session = %q, want session-1", got)
+	}
+	sub := fake.subscriptions()[0]
+	if sub.Type != "channel.follow" || sub.Version != "2" || sub.Condition != condition {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+	if sub.Transport.Method != TransportWebSocket || sub.Transport.SessionID != "session-1" {
+		t.Errorf("transport = %+v, want websocket with session-1", sub.Transport)
+	}
+
+	// with a session open, new subscriptions are created right away
+	if err := c.SubscribeUser("channel.raid", "1", helix.EventSubCondition{ToBroadcasterUserID: "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	subs := fake.subscriptions()
+	if len(subs) != 2 || subs[1].Transport.SessionID != "session-1" {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+}
+
+func TestWebSocketTimeouts(t *testing.T) {
+	setTimeouts(t, 100*time.Millisecond, 50*time.Millisecond)
+	cases := []struct {
+		name   string
+		script func(t *testing.T, ws *websocket.Conn)
+		// within bounds how long the session may survive.
+		within time.Duration
+	}{
+		{
+			name:   "no welcome",
+			script: func(t *testing.T, ws *websocket.Conn) {},
+			within: time.Second,
+		},
+		{
+			name: "keepalive missed",
+			script: func(t *testing.T, ws *websocket.Conn) {
+				welcome(t, ws, "session-1", 1)
+			},
+			within: 2 * time.Second,
+		},
+		{
+			name: "keepalives arrive, then stop",
+			script: func(t *testing.T, ws *websocket.Conn) {
+				welcome(t, ws, "session-1", 1)
+				for range 3 {
+					time.Sleep(500 * time.Millisecond)
+					sendWS(t, ws, "session_keepalive", map[string]any{})
+				}
+			},
+			within: 3 * time.Second,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer close(release)
+			srv := wsStandIn(t, map[string]func(*websocket.Conn){
+				"/ws": func(ws *websocket.Conn) {
+					tc.script(t, ws)
+					<-release
+				},
+			})
+			_, userClient := newFakeHelix(t)
+			c, _ := NewWebSocket(wsURL(srv, "/ws"), discardLogger, userClient)
+
+			start := time.Now()
+			select {
+			case err := <-runSessionAsync(t, c):
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if elapsed := time.Since(start); tc.name == "keepalives arrive, then stop" && elapsed < 1500*time.Millisecond {
+					t.Errorf("session ended after %s although keepalives arrived", elapsed)
+				}
+			case <-time.After(tc.within):
+				t.Fatalf("session still running after %s", tc.within)
+			}
+		})
+	}
+}
+
+func TestWebSocketReconnectHandover(t *testing.T) {
+	var (
+		oldSent   = make(chan struct{})
+		oldClosed = make(chan bool, 1)
+		release   = make(chan struct{})
+		srv       *httptest.Server
+	)
+	defer close(release)
+	srv = wsStandIn(t, map[string]func(*websocket.Conn){
+		"/ws": func(ws *websocket.Conn) {
+			welcome(t, ws, "session-1", 10)
+			sendWS(t, ws, "session_reconnect", map[string]any{"session": map[string]any{
+				"id": "session-1", "status": "reconnecting", "reconnect_url": wsURL(srv, "/reconnect"),
+			}})
+			// Twitch keeps delivering on the old connection until the
+			// new one is welcomed
+			time.Sleep(50 * time.Millisecond)
+			notify(t, ws, "during_handover")
+			close(oldSent)
+			oldClosed <- waitClosed(ws, 3*time.Second)
+		},
+		"/reconnect": func(ws *websocket.Conn) {
+			<-oldSent
+			welcome(t, ws, "session-1", 10)
+			notify(t, ws, "after_handover")
+			<-release
+		},
+	})
+	fake, userClient := newFakeHelix(t)
+	c, _ := NewWebSocket(wsURL(srv, "/ws"), discardLogger, userClient)
+
+	var (
+		mu      sync.Mutex
+		follows []string
+	)
+	_ = c.On("channel.follow", func(raw json.RawMessage) {
+		var ev struct {
+			UserLogin string `json:"user_login"`
+		}
+		_ = json.Unmarshal(raw, &ev)
+		mu.Lock()
+		follows = append(follows, ev.UserLogin)
+		mu.Unlock()
+	})
+	if err := c.SubscribeUser("channel.follow", "2", helix.EventSubCondition{BroadcasterUserID: "1234"}); err != nil {
+		t.Fatal(err)
+	}
+
+	runSessionAsync(t, c)
+	eventually(t, "both notifications", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(follows) == 2
+	})
+	mu.Lock()
+	// the connections are read concurrently, so their order is not kept
+	slices.Sort(follows)
+	if !slices.Equal(follows, []string{"after_handover", "during_handover"}) {
+		t.Errorf("notifications = %v", follows)
+	}
+	mu.Unlock()
+
+	select {
+	case closed := <-oldClosed:
+		if !closed {
+			t.Error("old connection was not closed after the handover")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old connection still open")
+	}
+	if got := c.session(); got != "session-1" {
+		t.Errorf("session = %q, want session-1", got)
+	}
+	// the subscriptions moved with the session
+	if n := len(fake.subscriptions()); n != 1 {
+		t.Errorf("created %d subscriptions, want 1", n)
+	}
+}
+
+func TestWebSocketReconnectWithoutWelcome(t *testing.T) {
+	setTimeouts(t, 200*time.Millisecond, 5*time.Second)
+	var (
+		release = make(chan struct{})
+		srv     *httptest.Server
+	)
+	defer close(release)
+	srv = wsStandIn(t, map[string]func(*websocket.Conn){
+		"/ws": func(ws *websocket.Conn) {
+			welcome(t, ws, "session-1", 10)
+			sendWS(t, ws, "session_reconnect", map[string]any{"session": map[string]any{
+				"id": "session-1", "status": "reconnecting", "reconnect_url": wsURL(srv, "/reconnect"),
+			}})
+			// the new connection never welcomes; the old one carries on
+			time.Sleep(500 * time.Millisecond)
+			notify(t, ws, "still_here")
+			<-release
+		},
+		"/reconnect": func(ws *websocket.Conn) {
+			<-release
+		},
+	})
+	_, userClient := newFakeHelix(t)
+	c, _ := NewWebSocket(wsURL(srv, "/ws"), discardLogger, userClient)
+
+	got := make(chan string, 1)
+	_ = c.On("channel.follow", func(raw json.RawMessage) {
+		var ev struct {
+			UserLogin string `json:"user_login"`
+		}
+		_ = json.Unmarshal(raw, &ev)
+		got <- ev.UserLogin
+	})
+
+	errs := runSessionAsync(t, c)
+	select {
+	case login := <-got:
+		if login != "still_here" {
+			t.Errorf("notification for %q", login)
+		}
+	case err := <-errs:
+		t.Fatalf("session ended: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no notification on the old connection")
+	}
+}
@@ -0,0 +1,254 @@
+// Package oauth obtains Twitch user access tokens through the device code
+// flow or the authorization code flow with a localhost redirect.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Twitch identity service. Point BaseURL at a local
+// fake to exercise the flows without Twitch.
+const DefaultBaseURL = "https://id.twitch.tv"
+
+// pollUnit scales the device flow polling interval; tests shorten it.
+var pollUnit = time.Second
+
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	BaseURL      string
+	HTTPClient   *http.Client
+}
+
+// Token is the result of a successful login.
+type Token struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scopes       []string `json:"scope"`
+	TokenType    string   `json:"token_type"`
+}
+
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (c Config) endpoint(path string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// postForm posts form values and decodes a 2xx JSON body into out. Non-2xx
+// responses are returned as *Error.
+func (c Config) postForm(ctx context.Context, path string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint(path), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e errorResponse
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return &Error{StatusCode: resp.StatusCode, Message: e.Message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Error is a non-2xx answer from the identity service.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("twitch identity service returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("twitch identity service returned status %d: %s", e.StatusCode, e.Message)
+}
+
+type deviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// DeviceLogin runs the device code flow. prompt is called once with the URL
+// the user has to open; DeviceLogin then polls until the user approves,
+// denies, or the code expires.
+func (c Config) DeviceLogin(ctx context.Context, prompt func(verificationURI, userCode string)) (Token, error) {
+	var dc deviceCodeResponse
+	err := c.postForm(ctx, "/oauth2/device", url.Values{
+		"client_id": {c.ClientID},
+		"scopes":    {strings.Join(c.Scopes, " ")},
+	}, &dc)
+	if err != nil {
+		return Token{}, fmt.Errorf("requesting device code: %w", err)
+	}
+	prompt(dc.VerificationURI, dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * pollUnit
+	if interval <= 0 {
+		interval = 5 * pollUnit
+	}
+	if dc.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(dc.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	form := url.Values{
+		"client_id":   {c.ClientID},
+		"scopes":      {strings.Join(c.Scopes, " ")},
+		"device_code": {dc.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return Token{}, fmt.Errorf("waiting for authorization: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		var token Token
+		err := c.postForm(ctx, "/oauth2/token", form, &token)
+		if err == nil {
+			return token, nil
+		}
+		var oerr *Error
+		if !errors.As(err, &oerr) {
+			return Token{}, err
+		}
+		switch oerr.Message {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * pollUnit
+		default:
+			return Token{}, fmt.Errorf("exchanging device code: %w", err)
+		}
+	}
+}
+
+// AuthCodeLogin runs the authorization code flow. It serves the redirect on
+// listenAddr, which must match a redirect URL registered for the application
+// (http://<listenAddr>/), and calls open with the URL the user has to visit.
+func (c Config) AuthCodeLogin(ctx context.Context, listenAddr string, open func(authURL string)) (Token, error) {
+	if c.ClientSecret == "" {
+		return Token{}, errors.New("the authorization code flow requires a client secret")
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return Token{}, err
+	}
+	redirectURI := "http://" + listenAddr + "/"
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return Token{}, err
+	}
+
+	type result struct {
+		code string
+		err  error
+	}
+	// Only the first callback counts; a browser retry must not block its
+	// handler on a result nobody reads.
+	results := make(chan result, 1)
+	deliver := func(res result) {
+		select {
+		case results <- res:
+		default:
+		}
+	}
+	srv := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("state") != state {
+				http.Error(w, "state mismatch", http.StatusBadRequest)
+				return
+			}
+			if e := q.Get("error"); e != "" {
+				http.Error(w, "authorization failed: "+q.Get("error_description"), http.StatusForbidden)
+				deliver(result{err: fmt.Errorf("authorization failed: %s: %s", e, q.Get("error_description"))})
+				return
+			}
+			_, _ = w.Write([]byte("Authorization complete. You can close this window.\n"))
+			deliver(result{code: q.Get("code")})
+		}),
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	authURL := c.endpoint("/oauth2/authorize") + "?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.ClientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {strings.Join(c.Scopes, " ")},
+		"state":         {state},
+	}.Encode()
+	open(authURL)
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return Token{}, fmt.Errorf("waiting for authorization: %w", ctx.Err())
+	case res = <-results:
+	}
+	if res.err != nil {
+		return Token{}, res.err
+	}
+
+	var token Token
+	err = c.postForm(ctx, "/oauth2/token", url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"code":          {res.code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {redirectURI},
+	}, &token)
+	if err != nil {
+		return Token{}, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	return token, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,245 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	pollUnit = time.Millisecond
+}
+
+type fakeResponse struct {
+	status int
+	body   any
+}
+
+// fakeID is a stand-in for id.twitch.tv that answers /oauth2/device with a
+// fixed code and /oauth2/token with the scripted responses in order.
+type fakeID struct {
+	t      *testing.T
+	script []fakeResponse
+
+	mu    sync.Mutex
+	forms []url.Values
+	polls []time.Time
+}
+
+func newFakeID(t *testing.T, script ...fakeResponse) (*fakeID, *httptest.Server) {
+	f := &fakeID{t: t, script: script}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeID) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		f.t.Errorf("parsing form: %v", err)
+	}
+	switch r.URL.Path {
+	case "/oauth2/device":
+		writeFake(w, fakeResponse{http.StatusOK, deviceCodeResponse{
+			DeviceCode:      "device-code",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "https://www.twitch.tv/activate",
+			ExpiresIn:       60,
+			Interval:        1,
+		}})
+	case "/oauth2/token":
+		f.mu.Lock()
+		f.forms = append(f.forms, r.PostForm)
+		f.polls = append(f.polls, time.Now())
+		if len(f.script) == 0 {
+			f.mu.Unlock()
+			f.t.Errorf("unexpected token request")
+			writeFake(w, fakeResponse{http.StatusInternalServerError, errorResponse{Message: "unexpected"}})
+			return
+		}
+		res := f.script[0]
+		f.script = f.script[1:]
+		f.mu.Unlock()
+		writeFake(w, res)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeFake(w http.ResponseWriter, res fakeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status)
+	_ = json.NewEncoder(w).Encode(res.body)
+}
+
+func pending(message string) fakeResponse {
+	return fakeResponse{http.StatusBadRequest, errorResponse{Status: http.StatusBadRequest, Message: message}}
+}
+
+var okToken = fakeResponse{http.StatusOK, Token{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600, Scopes: []string{"user:read:chat"}}}
+
+func TestDeviceLogin(t *testing.T) {
+	cases := []struct {
+		name      string
+		script    []fakeResponse
+		wantErr   string
+		wantPolls int
+		// minGap is the least time between the last two polls, in pollUnits.
+		minGap int
+	}{
+		{name: "approved", script: []fakeResponse{okToken}, wantPolls: 1},
+		{name: "authorization_pending", script: []fakeResponse{pending("authorization_pending"), pending("authorization_pending"), okToken}, wantPolls: 3},
+		{name: "slow_down", script: []fakeResponse{pending("slow_down"), okToken}, wantPolls: 2, minGap: 6},
+		{name: "access_denied", script: []fakeResponse{pending("authorization_pending"), pending("access_denied")}, wantErr: "access_denied", wantPolls: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, srv := newFakeID(t, tc.script...)
+			cfg := Config{ClientID: "client", Scopes: []string{"user:read:chat"}, BaseURL: srv.URL}
+
+			var prompted string
+			token, err := cfg.DeviceLogin(context.Background(), func(uri, code string) { prompted = code })
+			if prompted != "ABCD-EFGH" {
+				t.Errorf("prompted with user code %q", prompted)
+			}
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+					t.Errorf("unexpected token %+v", token)
+				}
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if len(f.polls) != tc.wantPolls {
+				t.Fatalf("got %d polls, want %d", len(f.polls), tc.wantPolls)
+			}
+			for _, form := range f.forms {
+				if form.Get("device_code") != "device-code" || form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+					t.Errorf("unexpected token request %v", form)
+				}
+			}
+			if tc.minGap > 0 {
+				gap := f.polls[len(f.polls)-1].Sub(f.polls[len(f.polls)-2])
+				if gap < time.Duration(tc.minGap)*pollUnit {
+					t.Errorf("polled again after %s, want at least %s", gap, time.Duration(tc.minGap)*pollUnit)
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceLoginExpires(t *testing.T) {
+	_, srv := newFakeID(t, pending("authorization_pending"), pending("authorization_pending"), pending("authorization_pending"))
+	cfg := Config{ClientID: "client", BaseURL: srv.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*pollUnit)
+	defer cancel()
+	if _, err := cfg.DeviceLogin(ctx, func(string, string) {}); err == nil {
+		t.Fatal("expected an error once the context is done")
+	}
+}
+
+// freeAddr returns a localhost address nothing listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// callback calls the redirect like a browser would and returns the status.
+func callback(t *testing.T, authURL string, params url.Values) int {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(u.Query().Get("redirect_uri") + "?" + params.Encode())
+	if err != nil {
+		t.Errorf("callback: %v", err)
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthCodeLogin(t *testing.T) {
+	f, srv := newFakeID(t, okToken)
+	cfg := Config{ClientID: "client", ClientSecret: "secret", Scopes: []string{"user:read:chat"}, BaseURL: srv.URL}
+
+	token, err := cfg.AuthCodeLogin(context.Background(), freeAddr(t), func(authURL string) {
+		state := mustQuery(t, authURL).Get("state")
+		if got := callback(t, authURL, url.Values{"state": {"forged"}, "code": {"evil"}}); got != http.StatusBadRequest {
+			t.Errorf("state mismatch answered %d, want 400", got)
+		}
+		if got := callback(t, authURL, url.Values{"state": {state}, "code": {"the-code"}}); got != http.StatusOK {
+			t.Errorf("callback answered %d, want 200", got)
+		}
+		// a browser retry must not hang
+		if got := callback(t, authURL, url.Values{"state": {state}, "code": {"the-code"}}); got != http.StatusOK {
+			t.Errorf("repeated callback answered %d, want 200", got)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.forms) != 1 {
+		t.Fatalf("got %d token requests, want 1", len(f.forms))
+	}
+	form := f.forms[0]
+	if form.Get("code") != "the-code" || form.Get("client_secret") != "secret" || form.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected token request %v", form)
+	}
+}
+
+func TestAuthCodeLoginDenied(t *testing.T) {
+	_, srv := newFakeID(t)
+	cfg := Config{ClientID: "client", ClientSecret: "secret", BaseURL: srv.URL}
+
+	_, err := cfg.AuthCodeLogin(context.Background(), freeAddr(t), func(authURL string) {
+		state := mustQuery(t, authURL).Get("state")
+		if got := callback(t, authURL, url.Values{"state": {state}, "error": {"access_denied"}, "error_description": {"The user denied you access"}}); got != http.StatusForbidden {
+			t.Errorf("denied callback answered %d, want 403", got)
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("got error %v, want access_denied", err)
+	}
+}
+
+func TestAuthCodeLoginRequiresSecret(t *testing.T) {
+	cfg := Config{ClientID: "client"}
+	if _, err := cfg.AuthCodeLogin(context.Background(), freeAddr(t), func(string) {}); err == nil {
+		t.Fatal("expected an error without a client secret")
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
@@ -0,0 +1,175 @@
+// Package ratelimit mirrors the Helix token bucket from the Ratelimit-*
+// response headers so requests can wait for points instead of hitting 429.
+package ratelimit
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bucket is a local model of one Helix rate-limit bucket. Twitch refills the
+// bucket continuously at Ratelimit-Limit points per minute; every response
+// corrects the model with the authoritative header values.
+type Bucket struct {
+	// reserve is the number of points left untouched, as a margin for
+	// requests already in flight.
+	reserve float64
+
+	mu        sync.Mutex
+	known     bool
+	limit     float64
+	remaining float64
+	resetAt   time.Time
+	updatedAt time.Time
+}
+
+func NewBucket(reserve int) *Bucket {
+	return &Bucket{reserve: float64(reserve)}
+}
+
+// Take blocks until a point is expected to be available and consumes it.
+// It returns how long the caller was queued.
+func (b *Bucket) Take(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	for {
+		wait := b.take(time.Now())
+		if wait <= 0 {
+			return time.Since(start), nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take consumes a point and returns 0, or returns how long to wait for one.
+func (b *Bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.known || b.limit <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.remaining-1 >= b.reserve {
+		b.remaining--
+		return 0
+	}
+
+	missing := b.reserve + 1 - b.remaining
+	wait := time.Duration(missing / b.limit * float64(time.Minute))
+	if untilReset := b.resetAt.Sub(now); untilReset > 0 && untilReset < wait {
+		wait = untilReset
+	}
+	return max(wait, 10*time.Millisecond)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !now.Before(b.resetAt) {
+		b.remaining = b.limit
+	} else if elapsed := now.Sub(b.updatedAt); elapsed > 0 {
+		b.remaining = min(b.limit, b.remaining+elapsed.Minutes()*b.limit)
+	}
+	b.updatedAt = now
+}
+
+// Update syncs the bucket with the Ratelimit-* headers of a response.
+// Responses without them (e.g. from id.twitch.tv) are ignored.
+func (b *Bucket) Update(h http.Header) {
+	limit, err := strconv.ParseFloat(h.Get("Ratelimit-Limit"), 64)
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.ParseFloat(h.Get("Ratelimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.known = true
+	b.limit = limit
+	b.remaining = remaining
+	b.updatedAt = time.Now()
+	if reset, err := strconv.ParseInt(h.Get("Ratelimit-Reset"), 10, 64); err == nil {
+		b.resetAt = time.Unix(reset, 0)
+	}
+}
+
+// ResetDelay returns how long until the bucket described by h is full again,
+// or 0 if the headers do not say.
+func ResetDelay(h http.Header, now time.Time) time.Duration {
+	reset, err := strconv.ParseInt(h.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return max(time.Unix(reset, 0).Sub(now), 0)
+}
+
+// Backoff returns a full-jitter exponential backoff for the given attempt
+// (0-based), between 0 and min(maxDelay, base*2^attempt).
+func Backoff(attempt int, base time.Duration, maxDelay time.Duration) time.Duration {
+	ceiling := base << attempt
+	if ceiling <= 0 || ceiling > maxDelay {
+		ceiling = maxDelay
+	}
+	return rand.N(ceiling) + 1
+}
+
+// idleTTL is how long a Registry keeps a bucket nobody used, e.g. the bucket
+// of a token that was refreshed since.
+const idleTTL = time.Hour
+
+// Registry hands out one Bucket per key, so every client spending the same
+// points paces against the same model.
+type Registry struct {
+	reserve int
+
+	mu        sync.Mutex
+	buckets   map[string]*registryEntry
+	lastPrune time.Time
+}
+
+type registryEntry struct {
+	bucket   *Bucket
+	lastUsed time.Time
+}
+
+func NewRegistry(reserve int) *Registry {
+	return &Registry{reserve: reserve, buckets: map[string]*registryEntry{}}
+}
+
+// Bucket returns the bucket for key, creating it on first use.
+func (r *Registry) Bucket(key string) *Bucket {
+	return r.bucket(key, time.Now())
+}
+
+func (r *Registry) bucket(key string, now time.Time) *Bucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastPrune) >= idleTTL {
+		for k, e := range r.buckets {
+			if now.Sub(e.lastUsed) >= idleTTL {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastPrune = now
+	}
+
+	e, ok := r.buckets[key]
+	if !ok {
+		e = &registryEntry{bucket: NewBucket(r.reserve)}
+		r.buckets[key] = e
+	}
+	e.lastUsed = now
+	return e.bucket
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func header(kv ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func TestUpdate(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second).Unix()
+	cases := []struct {
+		name          string
+		h             http.Header
+		wantKnown     bool
+		wantLimit     float64
+		wantRemaining float64
+		wantReset     bool
+	}{
+		{name: "no headers", h: header()},
+		{name: "id.twitch.tv response", h: header("Content-Type", "application/json")},
+		{name: "invalid limit", h: header("Ratelimit-Limit", "many", "Ratelimit-Remaining", "10")},
+		{name: "missing remaining", h: header("Ratelimit-Limit", "800")},
+		{
+			name:      "without reset",
+			h:         header("Ratelimit-Limit", "800", "Ratelimit-Remaining", "799"),
+			wantKnown: true, wantLimit: 800, wantRemaining: 799,
+		},
+		{
+			name:      "with reset",
+			h:         header("Ratelimit-Limit", "800", "Ratelimit-Remaining", "0", "Ratelimit-Reset", strconv.FormatInt(reset, 10)),
+			wantKnown: true, wantLimit: 800, wantRemaining: 0, wantReset: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBucket(5)
+			b.Update(tc.h)
+			if b.known != tc.wantKnown || b.limit != tc.wantLimit || b.remaining != tc.wantRemaining {
+				t.Errorf("got known=%v limit=%v remaining=%v, want known=%v limit=%v remaining=%v",
+					b.known, b.limit, b.remaining, tc.wantKnown, tc.wantLimit, tc.wantRemaining)
+			}
+			if got := b.resetAt.Unix() == reset; got != tc.wantReset {
+				t.Errorf("reset set = %v, want %v", got, tc.wantReset)
+			}
+		})
+	}
+}
+
+func TestTake(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cases := []struct {
+		name      string
+		known     bool
+		reserve   float64
+		remaining float64
+		resetIn   time.Duration
+		// elapsed is the time since the bucket was last synced.
+		elapsed       time.Duration
+		wantWait      time.Duration
+		wantRemaining float64
+	}{
+		{name: "unknown bucket never waits", known: false, wantWait: 0},
+		{name: "points above reserve", known: true, reserve: 5, remaining: 100, resetIn: time.Minute, wantRemaining: 99},
+		{name: "last point above reserve", known: true, reserve: 5, remaining: 6, resetIn: time.Minute, wantRemaining: 5},
+		// 1 point missing at 60 points/minute is one second
+		{name: "reserve reached", known: true, reserve: 5, remaining: 5, resetIn: time.Minute, wantWait: time.Second, wantRemaining: 5},
+		{name: "reserve zero", known: true, reserve: 0, remaining: 0.5, resetIn: time.Minute, wantWait: 500 * time.Millisecond, wantRemaining: 0.5},
+		{name: "wait capped at reset", known: true, reserve: 50, remaining: 0, resetIn: 2 * time.Second, wantWait: 2 * time.Second},
+		{name: "refilled after reset", known: true, reserve: 5, remaining: 0, resetIn: -time.Second, wantRemaining: 59},
+		{name: "refilled while waiting", known: true, reserve: 5, remaining: 5, resetIn: time.Minute, elapsed: 2 * time.Second, wantRemaining: 6},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &Bucket{
+				reserve:   tc.reserve,
+				known:     tc.known,
+				limit:     60,
+				remaining: tc.remaining,
+				resetAt:   now.Add(tc.resetIn),
+				updatedAt: now.Add(-tc.elapsed),
+			}
+			if got := b.take(now); got != tc.wantWait {
+				t.Errorf("wait = %s, want %s", got, tc.wantWait)
+			}
+			if tc.known && b.remaining != tc.wantRemaining {
+				t.Errorf("remaining = %v, want %v", b.remaining, tc.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestTakeHonoursContext(t *testing.T) {
+	b := &Bucket{
+		reserve:   10,
+		known:     true,
+		limit:     1,
+		remaining: 0,
+		resetAt:   time.Now().Add(time.Hour),
+		updatedAt: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.Take(ctx); err == nil {
+		t.Fatal("expected the context error while the bucket is empty")
+	}
+}
+
+func TestResetDelay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	cases := []struct {
+		name string
+		h    http.Header
+		want time.Duration
+	}{
+		{name: "missing", h: header(), want: 0},
+		{name: "invalid", h: header("Ratelimit-Reset", "soon"), want: 0},
+		{name: "in the past", h: header("Ratelimit-Reset", "1699999990"), want: 0},
+		{name: "in the future", h: header("Ratelimit-Reset", "1700000012"), want: 12 * time.Second},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ResetDelay(tc.h, now); got != tc.want {
+				t.Errorf("ResetDelay = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{10, time.Second},
+		{100, time.Second},
+	}
+	for _, tc := range cases {
+		for range 100 {
+			got := Backoff(tc.attempt, 100*time.Millisecond, time.Second)
+			if got <= 0 || got > tc.ceiling {
+				t.Fatalf("Backoff(%d) = %s, want in (0, %s]", tc.attempt, got, tc.ceiling)
+			}
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	r := NewRegistry(5)
+
+	a := r.bucket("api.twitch.tv/app", now)
+	if r.bucket("api.twitch.tv/app", now.Add(time.Minute)) != a {
+		t.Fatal("same key must share a bucket")
+	}
+	if r.bucket("api.twitch.tv/user", now) == a {
+		t.Fatal("different keys must not share a bucket")
+	}
+	if a.reserve != 5 {
+		t.Errorf("reserve = %v, want 5", a.reserve)
+	}
+
+	// the user bucket goes idle, the app bucket stays in use
+	r.bucket("api.twitch.tv/app", now.Add(idleTTL))
+	r.bucket("api.twitch.tv/app", now.Add(idleTTL+time.Minute))
+	if _, ok := r.buckets["api.twitch.tv/user"]; ok {
+		t.Error("idle bucket was not dropped")
+	}
+	if r.bucket("api.twitch.tv/app", now.Add(idleTTL+2*time.Minute)) != a {
+		t.Error("bucket in use was dropped")
+	}
+}
@@ -0,0 +1,161 @@
+package sessions
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// History keeps the last finished sessions of every channel. With a file,
+// sessions are appended to it as JSON lines, and the file is rewritten with
+// only the kept sessions once it holds twice as many.
+type History struct {
+	path  string
+	limit int
+
+	mu       sync.Mutex
+	sessions map[string][]Session // oldest first
+	kept     int
+	lines    int
+}
+
+// NewHistory returns a history holding at most limit sessions per channel in
+// memory only.
+func NewHistory(limit int) *History {
+	return &History{limit: max(limit, 1), sessions: map[string][]Session{}}
+}
+
+// OpenHistory loads the history kept in path, creating the file if needed.
+func OpenHistory(path string, limit int) (*History, error) {
+	h := NewHistory(limit)
+	h.path = path
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return h, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var s Session
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			// a torn last line from a crash mid-write
+			continue
+		}
+		h.add(s)
+		h.lines++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Add records a finished session. Persisting is best-effort: the session is
+// kept in memory even when the file cannot be written.
+func (h *History) Add(s Session) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(s)
+	if h.path == "" {
+		return nil
+	}
+
+	if h.lines+1 > 2*h.kept {
+		return h.rewrite()
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	h.lines++
+	return nil
+}
+
+func (h *History) add(s Session) {
+	list := append(h.sessions[s.Channel], s)
+	if len(list) > h.limit {
+		list = list[len(list)-h.limit:]
+	} else {
+		h.kept++
+	}
+	h.sessions[s.Channel] = list
+}
+
+// rewrite replaces the file with the kept sessions. Callers hold h.mu.
+func (h *History) rewrite() error {
+	f, err := os.CreateTemp(filepath.Dir(h.path), "."+filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	lines := 0
+	for _, list := range h.sessions {
+		for _, s := range list {
+			if err := enc.Encode(s); err != nil {
+				f.Close()
+				return err
+			}
+			lines++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	h.lines = lines
+	return nil
+}
+
+// Last returns the most recent session of channel.
+func (h *History) Last(channel string) (Session, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := h.sessions[channel]
+	if len(list) == 0 {
+		return Session{}, false
+	}
+	return list[len(list)-1], true
+}
+
+// List returns the sessions of channel, newest first.
+func (h *History) List(channel string) []Session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := h.sessions[channel]
+	out := make([]Session, len(list))
+	for i, s := range list {
+		out[len(list)-1-i] = s
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package sessions
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func session(channel string, n int) Session {
+	return Session{
+		Channel:   channel,
+		StreamID:  channel + "-" + string(rune('a'+n)),
+		StartedAt: time.Unix(int64(1_700_000_000+n*3600), 0).UTC(),
+	}
+}
+
+func streamIDs(list []Session) []string {
+	ids := make([]string, len(list))
+	for i, s := range list {
+		ids[i] = s.StreamID
+	}
+	return ids
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestHistoryRing(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit int
+		adds  int
+		want  []string // newest first
+	}{
+		{name: "empty", limit: 3, adds: 0, want: []string{}},
+		{name: "below limit", limit: 3, adds: 2, want: []string{"x-b", "x-a"}},
+		{name: "at limit", limit: 3, adds: 3, want: []string{"x-c", "x-b", "x-a"}},
+		{name: "oldest dropped", limit: 3, adds: 5, want: []string{"x-e", "x-d", "x-c"}},
+		{name: "limit below one keeps one", limit: 0, adds: 2, want: []string{"x-b"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHistory(tc.limit)
+			for i := range tc.adds {
+				if err := h.Add(session("x", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// other channels have their own ring
+			if err := h.Add(session("y", 0)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := streamIDs(h.List("x")); !slices.Equal(got, tc.want) {
+				t.Errorf("List = %v, want %v", got, tc.want)
+			}
+			last, ok := h.Last("x")
+			if ok != (len(tc.want) > 0) || (ok && last.StreamID != tc.want[0]) {
+				t.Errorf("Last = %q, %v", last.StreamID, ok)
+			}
+			if got := streamIDs(h.List("y")); !slices.Equal(got, []string{"y-a"}) {
+				t.Errorf("List(y) = %v", got)
+			}
+		})
+	}
+}
+
+func TestHistoryRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+	h, err := OpenHistory(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 9 {
+		if err := h.Add(session("x", i)); err != nil {
+			t.Fatal(err)
+		}
+		// the file holds at most twice the kept sessions
+		if n := countLines(t, path); n > 4 {
+			t.Fatalf("after %d sessions the file has %d lines, want at most 4", i+1, n)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("directory holds %d files, want only the history", len(files))
+	}
+
+	h, err = OpenHistory(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := streamIDs(h.List("x")), []string{"x-i", "x-h"}; !slices.Equal(got, want) {
+		t.Errorf("reopened List = %v, want %v", got, want)
+	}
+	if got := h.List("x")[0].StartedAt; !got.Equal(session("x", 8).StartedAt) {
+		t.Errorf("StartedAt = %s after reopen", got)
+	}
+}
+
+func TestOpenHistory(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		limit   int
+		want    []string
+	}{
+		{name: "missing file", want: []string{}},
+		{
+			name:    "lines beyond the limit",
+			content: `{"channel":"x","stream_id":"1"}` + "\n" + `{"channel":"x","stream_id":"2"}` + "\n" + `{"channel":"x","stream_id":"3"}` + "\n",
+			limit:   2,
+			want:    []string{"3", "2"},
+		},
+		{
+			name:    "torn last line",
+			content: `{"channel":"x","stream_id":"1"}` + "\n" + `{"channel":"x","str`,
+			limit:   5,
+			want:    []string{"1"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.jsonl")
+			if tc.content != "" {
+				if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h, err := OpenHistory(path, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := streamIDs(h.List("x")); !slices.Equal(got, tc.want) {
+				t.Errorf("List = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistoryAddKeepsSessionWhenFileFails(t *testing.T) {
+	// the parent of the history file does not exist
+	h := NewHistory(2)
+	h.path = filepath.Join(t.TempDir(), "missing", "sessions.jsonl")
+	if err := h.Add(session("x", 0)); err == nil {
+		t.Error("expected an error writing the history file")
+	}
+	if _, ok := h.Last("x"); !ok {
+		t.Error("session was not kept in memory")
+	}
+}
@@ -0,0 +1,181 @@
+// Package sessions records the streams of a channel: start, end, duration,
+// peak and time-weighted average viewers and the categories visited. Finished
+// sessions are kept in a bounded history.
+package sessions
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Session is one stream of a channel.
+type Session struct {
+	Channel   string    `json:"channel"`
+	StreamID  string    `json:"stream_id"`
+	StartedAt time.Time `json:"started_at"`
+	// EndedAt is when the end was observed; zero while the stream is live.
+	EndedAt         time.Time  `json:"ended_at,omitzero"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	PeakViewers     int        `json:"peak_viewers"`
+	AverageViewers  float64    `json:"average_viewers"`
+	Categories      []Category `json:"categories"`
+}
+
+// Category is a category visited during a session, in order of the first
+// visit, with the time spent in it.
+type Category struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Sample is a poll of a live channel.
+type Sample struct {
+	StreamID     string
+	StartedAt    time.Time
+	Viewers      int
+	CategoryID   string
+	CategoryName string
+	At           time.Time
+}
+
+// open is a session still being recorded. Viewers are interpolated linearly
+// between samples; a category holds until the next sample.
+type open struct {
+	Session
+	firstAt       time.Time
+	last          Sample
+	viewerSeconds float64
+}
+
+// Tracker turns samples into sessions.
+type Tracker struct {
+	history *History
+	logger  *slog.Logger
+
+	mu   sync.Mutex
+	open map[string]*open
+}
+
+func NewTracker(history *History, logger *slog.Logger) *Tracker {
+	return &Tracker{history: history, logger: logger, open: map[string]*open{}}
+}
+
+// Observe records a sample of a live channel. A sample with a different
+// stream ID ends the open session and starts a new one.
+func (t *Tracker) Observe(channel string, s Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o, ok := t.open[channel]
+	if ok && o.StreamID != s.StreamID {
+		t.end(channel, o, s.At)
+		ok = false
+	}
+	if !ok {
+		o = &open{
+			Session: Session{Channel: channel, StreamID: s.StreamID, StartedAt: s.StartedAt},
+			firstAt: s.At,
+		}
+		if o.StartedAt.IsZero() {
+			o.StartedAt = s.At
+		}
+		t.open[channel] = o
+	} else {
+		o.advance(s)
+	}
+
+	o.last = s
+	o.PeakViewers = max(o.PeakViewers, s.Viewers)
+	if s.CategoryID != "" && o.category(s.CategoryID) == nil {
+		o.Categories = append(o.Categories, Category{ID: s.CategoryID, Name: s.CategoryName})
+	}
+}
+
+// End finishes the open session of channel, if any.
+func (t *Tracker) End(channel string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if o, ok := t.open[channel]; ok {
+		t.end(channel, o, at)
+	}
+}
+
+// Discard forgets the open session of channel without recording it, e.g.
+// when the channel is no longer watched.
+func (t *Tracker) Discard(channel string) {
+	t.mu.Lock()
+	delete(t.open, channel)
+	t.mu.Unlock()
+}
+
+// Current returns the open session of channel, with the stats up to the last
+// sample.
+func (t *Tracker) Current(channel string) (Session, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.open[channel]
+	if !ok {
+		return Session{}, false
+	}
+	return o.snapshot(), true
+}
+
+// Last returns the most recent finished session of channel.
+func (t *Tracker) Last(channel string) (Session, bool) {
+	return t.history.Last(channel)
+}
+
+// History returns the finished sessions of channel, newest first.
+func (t *Tracker) History(channel string) []Session {
+	return t.history.List(channel)
+}
+
+// end records o as finished at. Callers hold t.mu.
+func (t *Tracker) end(channel string, o *open, at time.Time) {
+	delete(t.open, channel)
+	s := o.snapshot()
+	s.EndedAt = at
+	s.DurationSeconds = at.Sub(s.StartedAt).Seconds()
+	if err := t.history.Add(s); err != nil {
+		t.logger.Warn("failed to persist stream session", "channel", channel, "err", err)
+	}
+}
+
+// advance credits the time since the last sample to the viewers and to the
+// last sample's category.
+func (o *open) advance(s Sample) {
+	dt := s.At.Sub(o.last.At).Seconds()
+	if dt <= 0 {
+		return
+	}
+	o.viewerSeconds += float64(o.last.Viewers+s.Viewers) / 2 * dt
+	if c := o.category(o.last.CategoryID); c != nil {
+		c.Seconds += dt
+	}
+}
+
+func (o *open) category(id string) *Category {
+	for i := range o.Categories {
+		if o.Categories[i].ID == id {
+			return &o.Categories[i]
+		}
+	}
+	return nil
+}
+
+// snapshot returns the session with its stats computed over the samples seen
+// so far; nothing is extrapolated past the last sample.
+func (o *open) snapshot() Session {
+	s := o.Session
+	s.Categories = make([]Category, len(o.Categories))
+	copy(s.Categories, o.Categories)
+	s.DurationSeconds = o.last.At.Sub(s.StartedAt).Seconds()
+	if span := o.last.At.Sub(o.firstAt).Seconds(); span > 0 {
+		s.AverageViewers = o.viewerSeconds / span
+	} else {
+		s.AverageViewers = float64(o.last.Viewers)
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package sessions
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	sample := func(stream string, minutes, viewers int, category string) Sample {
+		return Sample{StreamID: stream, StartedAt: start, Viewers: viewers, CategoryID: category, CategoryName: "name-" + category, At: at(minutes)}
+	}
+
+	tr := NewTracker(NewHistory(5), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tr.Observe("x", sample("1", 0, 100, "a"))
+	tr.Observe("x", sample("1", 10, 300, "a"))
+	tr.Observe("x", sample("1", 20, 300, "b"))
+
+	cur, ok := tr.Current("x")
+	if !ok {
+		t.Fatal("no open session")
+	}
+	// 10m at an average of 200, then 10m at 300
+	if cur.AverageViewers != 250 || cur.PeakViewers != 300 || cur.DurationSeconds != 1200 {
+		t.Errorf("current = avg %v peak %d duration %v", cur.AverageViewers, cur.PeakViewers, cur.DurationSeconds)
+	}
+	wantCategories := []Category{{ID: "a", Name: "name-a", Seconds: 1200}, {ID: "b", Name: "name-b"}}
+	if !slices.Equal(cur.Categories, wantCategories) {
+		t.Errorf("categories = %+v, want %+v", cur.Categories, wantCategories)
+	}
+
+	// a new stream ID ends the session at its first sample
+	tr.Observe("x", sample("2", 30, 50, "b"))
+	last, ok := tr.Last("x")
+	if !ok || last.StreamID != "1" || !last.EndedAt.Equal(at(30)) || last.DurationSeconds != 1800 {
+		t.Errorf("last = %+v", last)
+	}
+
+	tr.End("x", at(40))
+	tr.Discard("x")
+	if got := streamIDs(tr.History("x")); !slices.Equal(got, []string{"2", "1"}) {
+		t.Errorf("history = %v", got)
+	}
+	if _, ok := tr.Current("x"); ok {
+		t.Error("session still open after End")
+	}
+}
@@ -0,0 +1,213 @@
+// Package statestore persists counters derived from EventSub notifications so
+// they survive restarts. Counters are grouped by key, the broadcaster user ID,
+// so a renamed channel keeps its totals.
+package statestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps monotonically growing counters per key.
+type Store interface {
+	// Load returns the counters stored for key by name; an unknown key has
+	// no counters.
+	Load(key string) (map[string]float64, error)
+	// Add adds delta to the named counter of key.
+	Add(key string, name string, delta float64) error
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "counters.wal"
+)
+
+// FileStore keeps the counters in a directory: a snapshot of all counters,
+// written periodically by Snapshot, and a write-ahead log every Add is
+// appended to in between. Opening the store replays the log on top of the
+// snapshot.
+//
+// Log entries are written without fsync, so they survive a crash of the
+// exporter but not necessarily of the machine; snapshots are synced.
+type FileStore struct {
+	dir string
+
+	mu       sync.Mutex
+	counters map[string]map[string]float64
+	// seq numbers the log entries. The snapshot records the last entry it
+	// contains, so entries still in the log after a crash between writing
+	// the snapshot and truncating the log are not applied twice.
+	seq uint64
+	wal *os.File
+	// dirty is set when entries were logged since the last snapshot.
+	dirty bool
+}
+
+type snapshot struct {
+	Seq      uint64                        `json:"seq"`
+	Counters map[string]map[string]float64 `json:"counters"`
+}
+
+type walEntry struct {
+	Seq   uint64  `json:"seq"`
+	Key   string  `json:"key"`
+	Name  string  `json:"name"`
+	Delta float64 `json:"delta"`
+}
+
+// OpenFileStore restores the counters kept in dir, creating it if needed, and
+// compacts the log into a fresh snapshot.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir, counters: map[string]map[string]float64{}}
+
+	b, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		var snap snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("decoding state snapshot: %w", err)
+		}
+		s.seq = snap.Seq
+		for key, counters := range snap.Counters {
+			for name, v := range counters {
+				s.add(key, name, v)
+			}
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	s.wal, err = os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s.dirty = true
+	if err := s.Snapshot(); err != nil {
+		s.wal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies the log entries newer than the snapshot. A torn last line,
+// left by a crash mid-write, ends the replay.
+func (s *FileStore) replay() error {
+	f, err := os.Open(filepath.Join(s.dir, walFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			break
+		}
+		if e.Seq <= s.seq {
+			continue
+		}
+		s.seq = e.Seq
+		s.add(e.Key, e.Name, e.Delta)
+	}
+	return sc.Err()
+}
+
+func (s *FileStore) add(key string, name string, delta float64) {
+	counters, ok := s.counters[key]
+	if !ok {
+		counters = map[string]float64{}
+		s.counters[key] = counters
+	}
+	counters[name] += delta
+}
+
+func (s *FileStore) Load(key string) (map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]float64, len(s.counters[key]))
+	for name, v := range s.counters[key] {
+		out[name] = v
+	}
+	return out, nil
+}
+
+func (s *FileStore) Add(key string, name string, delta float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	s.add(key, name, delta)
+	s.dirty = true
+
+	b, err := json.Marshal(walEntry{Seq: s.seq, Key: key, Name: name, Delta: delta})
+	if err != nil {
+		return err
+	}
+	_, err = s.wal.Write(append(b, '\n'))
+	return err
+}
+
+// Snapshot writes all counters to the snapshot file and truncates the log.
+// The snapshot replaces the old one atomically.
+func (s *FileStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(snapshot{Seq: s.seq, Counters: s.counters})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, snapshotFile)
+	f, err := os.CreateTemp(s.dir, "."+snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Close writes a final snapshot and closes the log.
+func (s *FileStore) Close() error {
+	err := s.Snapshot()
+	return errors.Join(err, s.wal.Close())
+}
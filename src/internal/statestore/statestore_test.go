@@ -0,0 +1,230 @@
+package statestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustLoad(t *testing.T, s *FileStore, key string) map[string]float64 {
+	t.Helper()
+	got, err := s.Load(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// walLines returns the entries in the log of dir.
+func walLines(t *testing.T, dir string) []walEntry {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []walEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e walEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("log line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestOpenReplay(t *testing.T) {
+	cases := []struct {
+		name     string
+		snapshot string
+		wal      string
+		want     map[string]float64
+		wantSeq  uint64
+		wantErr  bool
+	}{
+		{name: "empty directory", want: map[string]float64{}},
+		{
+			name:     "snapshot only",
+			snapshot: `{"seq":2,"counters":{"1":{"bits":100,"subs":2}}}`,
+			want:     map[string]float64{"bits": 100, "subs": 2},
+			wantSeq:  2,
+		},
+		{
+			name:    "log only",
+			wal:     `{"seq":1,"key":"1","name":"bits","delta":50}` + "\n" + `{"seq":2,"key":"1","name":"bits","delta":25}` + "\n",
+			want:    map[string]float64{"bits": 75},
+			wantSeq: 2,
+		},
+		{
+			name:     "log on top of snapshot",
+			snapshot: `{"seq":2,"counters":{"1":{"bits":100}}}`,
+			wal:      `{"seq":3,"key":"1","name":"bits","delta":10}` + "\n" + `{"seq":4,"key":"1","name":"subs","delta":1}` + "\n",
+			want:     map[string]float64{"bits": 110, "subs": 1},
+			wantSeq:  4,
+		},
+		{
+			// a crash between writing the snapshot and truncating the log
+			name:     "entries already in snapshot",
+			snapshot: `{"seq":3,"counters":{"1":{"bits":110}}}`,
+			wal:      `{"seq":2,"key":"1","name":"bits","delta":100}` + "\n" + `{"seq":3,"key":"1","name":"bits","delta":10}` + "\n",
+			want:     map[string]float64{"bits": 110},
+			wantSeq:  3,
+		},
+		{
+			name:    "torn last line",
+			wal:     `{"seq":1,"key":"1","name":"bits","delta":50}` + "\n" + `{"seq":2,"key":"1","na`,
+			want:    map[string]float64{"bits": 50},
+			wantSeq: 1,
+		},
+		{
+			name:    "other keys",
+			wal:     `{"seq":1,"key":"2","name":"bits","delta":50}` + "\n",
+			want:    map[string]float64{},
+			wantSeq: 1,
+		},
+		{name: "corrupt snapshot", snapshot: `{"seq":`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tc.snapshot != "" {
+				writeFile(t, dir, snapshotFile, tc.snapshot)
+			}
+			if tc.wal != "" {
+				writeFile(t, dir, walFile, tc.wal)
+			}
+
+			s, err := OpenFileStore(dir)
+			if tc.wantErr {
+				if err == nil {
+					s.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if got := mustLoad(t, s, "1"); !maps.Equal(got, tc.want) {
+				t.Errorf("counters = %v, want %v", got, tc.want)
+			}
+			if s.seq != tc.wantSeq {
+				t.Errorf("seq = %d, want %d", s.seq, tc.wantSeq)
+			}
+			// opening compacts the log into the snapshot
+			if entries := walLines(t, dir); len(entries) != 0 {
+				t.Errorf("log has %d entries after open, want none", len(entries))
+			}
+		})
+	}
+}
+
+func TestAddSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delta := range []float64{100, 50} {
+		if err := s.Add("1", "bits", delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add("1", "subs", 1); err != nil {
+		t.Fatal(err)
+	}
+	// crash: the log is left behind without a final snapshot
+	s.wal.Close()
+
+	if entries := walLines(t, dir); len(entries) != 3 || entries[2].Seq != 3 {
+		t.Fatalf("log entries = %+v, want seq 1..3", entries)
+	}
+
+	s, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := map[string]float64{"bits": 150, "subs": 1}
+	if got := mustLoad(t, s, "1"); !maps.Equal(got, want) {
+		t.Errorf("counters = %v, want %v", got, want)
+	}
+}
+
+func TestSnapshotCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("1", "bits", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("2", "bits", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := walLines(t, dir); len(entries) != 0 {
+		t.Fatalf("log has %d entries after snapshot, want none", len(entries))
+	}
+	b, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Seq != 2 || snap.Counters["1"]["bits"] != 100 || snap.Counters["2"]["bits"] != 7 {
+		t.Errorf("snapshot = %+v", snap)
+	}
+
+	// entries after the snapshot start the log over and continue the sequence
+	if err := s.Add("1", "bits", 1); err != nil {
+		t.Fatal(err)
+	}
+	if entries := walLines(t, dir); len(entries) != 1 || entries[0].Seq != 3 {
+		t.Errorf("log entries = %+v, want only seq 3", entries)
+	}
+
+	// no temporary snapshot files are left behind
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			t.Errorf("leftover file %s", f.Name())
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := mustLoad(t, s, "1"); got["bits"] != 101 {
+		t.Errorf("bits = %v after reopen, want 101", got["bits"])
+	}
+}
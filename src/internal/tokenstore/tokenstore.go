@@ -0,0 +1,147 @@
+// Package tokenstore persists Twitch user tokens so rotated refresh tokens
+// survive restarts.
+package tokenstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Load when the store holds no token yet.
+var ErrNotFound = errors.New("no token stored")
+
+// Token is a user access token together with the refresh token that renews it.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Scopes       []string  `json:"scopes,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+}
+
+// Store loads and saves a single user token.
+type Store interface {
+	Load() (Token, error)
+	Save(token Token) error
+}
+
+// FileStore keeps the token as JSON in a file readable only by its owner.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load() (Token, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Token{}, ErrNotFound
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	return decode(b)
+}
+
+// Save replaces the file atomically: the token is written to a temporary file
+// in the same directory and renamed over the old one, so a crash never leaves
+// a half-written token behind.
+func (s *FileStore) Save(token Token) error {
+	b, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// ExecStore delegates to an external program in the style of git credential
+// helpers, e.g. to keep tokens in a secret manager:
+//
+//	<command> get    prints the token as JSON on stdout (no output: no token)
+//	<command> store  reads the token as JSON on stdin
+type ExecStore struct {
+	command string
+	timeout time.Duration
+}
+
+func NewExecStore(command string) *ExecStore {
+	return &ExecStore{command: command, timeout: 30 * time.Second}
+}
+
+func (s *ExecStore) Load() (Token, error) {
+	out, err := s.run("get", nil)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return Token{}, ErrNotFound
+	}
+	return decode(out)
+}
+
+func (s *ExecStore) Save(token Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.run("store", b)
+	return err
+}
+
+func (s *ExecStore) run(action string, stdin []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.command, action)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("token store %s %s: %w: %s", s.command, action, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func decode(b []byte) (Token, error) {
+	var token Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return Token{}, fmt.Errorf("decoding token: %w", err)
+	}
+	if token.RefreshToken == "" {
+		return Token{}, errors.New("stored token has no refresh token")
+	}
+	return token, nil
+}
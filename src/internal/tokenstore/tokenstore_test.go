@@ -0,0 +1,116 @@
+package tokenstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func token(refresh string) Token {
+	return Token{
+		AccessToken:  "access-" + refresh,
+		RefreshToken: refresh,
+		Scopes:       []string{"channel:read:subscriptions"},
+		ExpiresAt:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(filepath.Join(dir, "token.json"))
+
+	if _, err := s.Load(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Load of a missing file = %v, want ErrNotFound", err)
+	}
+
+	// a refresh rotates the token: the second Save replaces the first
+	for _, want := range []Token{token("first"), token("second")} {
+		if err := s.Save(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Load = %+v, want %+v", got, want)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "token.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("token file mode = %o, want 600", mode)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the token", len(entries))
+	}
+}
+
+func TestFileStoreRejectsTokenWithoutRefreshToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := os.WriteFile(path, []byte(`{"access_token":"access"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path).Load(); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Load = %v, want an error about the missing refresh token", err)
+	}
+}
+
+// helper writes a credential helper script keeping the token in a file next
+// to it.
+func helper(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "helper")
+	script := "#!/bin/sh\nstore=" + filepath.Join(dir, "stored") + "\n" + body
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecStore(t *testing.T) {
+	s := NewExecStore(helper(t, `case "$1" in
+get) [ -f "$store" ] && cat "$store"; exit 0 ;;
+store) cat > "$store" ;;
+esac
+`))
+
+	if _, err := s.Load(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Load with no output = %v, want ErrNotFound", err)
+	}
+	for _, want := range []Token{token("first"), token("second")} {
+		if err := s.Save(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Load = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestExecStoreReportsStderr(t *testing.T) {
+	s := NewExecStore(helper(t, "echo \"vault sealed\" >&2\nexit 1\n"))
+
+	if _, err := s.Load(); err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Errorf("Load = %v, want the helper's stderr", err)
+	}
+	if err := s.Save(token("first")); err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Errorf("Save = %v, want the helper's stderr", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/webgrip/twitch_exporter/collector"
+)
+
+// defaultProbeModule is used when /probe is called without a module and the
+// config file does not define one named "default".
+const defaultProbeModule = "default"
+
+var defaultProbeCollectors = []string{"channel_core"}
+
+// probeModules holds the module -> collectors mapping from the config file.
+type probeModules struct {
+	mu      sync.RWMutex
+	modules map[string][]string
+}
+
+func (m *probeModules) set(modules map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules = modules
+}
+
+func (m *probeModules) get(name string) ([]string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if collectors, ok := m.modules[name]; ok {
+		return collectors, true
+	}
+	if name == defaultProbeModule {
+		return defaultProbeCollectors, true
+	}
+	return nil, false
+}
+
+// prober serves /probe?target=<login>&module=<name> in the style of
+// blackbox_exporter, so Prometheus service discovery can own the channel list.
+type prober struct {
+	logger    *slog.Logger
+	client    *helix.Client
+	watchlist *collector.SharedWatchlist
+	modules   *probeModules
+
+	timeoutOffset float64
+}
+
+func (p *prober) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		target := strings.ToLower(strings.TrimSpace(params.Get("target")))
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		if !loginPattern.MatchString(target) {
+			http.Error(w, fmt.Sprintf("invalid target %q", target), http.StatusBadRequest)
+			return
+		}
+
+		moduleName := params.Get("module")
+		if moduleName == "" {
+			moduleName = defaultProbeModule
+		}
+		names, ok := p.modules.get(moduleName)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+
+		if p.client == nil {
+			http.Error(w, "Twitch client is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Probing the self channel keeps role=self so self-only collectors report it.
+		role := collector.RoleWatch
+		if target == p.watchlist.Get().SelfLogin() {
+			role = collector.RoleSelf
+		}
+
+		logger := p.logger.With("target", target, "module", moduleName)
+		exporter, err := collector.NewProbeExporter(logger, p.client, target, role, names)
+		if err != nil {
+			logger.Error("failed to create probe", "err", err)
+			http.Error(w, fmt.Sprintf("failed to create probe: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := scrapeContext(r, p.timeoutOffset)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exporter.WithContext(ctx))
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			ErrorLog:      promHTTPLogger{logger: logger},
+			ErrorHandling: promhttp.ContinueOnError,
+		}).ServeHTTP(w, r)
+	}
+}
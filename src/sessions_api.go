@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/webgrip/twitch_exporter/internal/sessions"
+)
+
+// sessionsAPI serves GET /api/sessions?channel=<login>: the live stream, if
+// any, and the finished ones kept in the history, newest first.
+type sessionsAPI struct {
+	tracker *sessions.Tracker
+}
+
+type sessionsResponse struct {
+	Channel  string             `json:"channel"`
+	Current  *sessions.Session  `json:"current"`
+	Sessions []sessions.Session `json:"sessions"`
+}
+
+func (a *sessionsAPI) list(w http.ResponseWriter, r *http.Request) {
+	channel := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("channel")))
+	if channel == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("missing channel parameter"))
+		return
+	}
+
+	res := sessionsResponse{Channel: channel, Sessions: a.tracker.History(channel)}
+	if current, ok := a.tracker.Current(channel); ok {
+		res.Current = &current
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/webgrip/twitch_exporter/collector"
+	"github.com/webgrip/twitch_exporter/internal/sessions"
+	"github.com/webgrip/twitch_exporter/internal/statestore"
+)
+
+// setupStateStore opens the state directory, if configured, and hands it to
+// the collectors. The write-ahead log is compacted into a snapshot on
+// --state.snapshot-interval.
+func setupStateStore(logger *slog.Logger) error {
+	if *stateDir == "" {
+		return nil
+	}
+	store, err := statestore.OpenFileStore(*stateDir)
+	if err != nil {
+		return err
+	}
+	logger.Info("persisting eventsub counters", "dir", *stateDir)
+	collector.SetStateStore(store)
+
+	if *stateSnapshotInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(*stateSnapshotInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := store.Snapshot(); err != nil {
+					logger.Warn("failed to write state snapshot", "err", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// newSessionTracker keeps the stream session history in --state.dir, or in
+// memory when it is not set.
+func newSessionTracker(logger *slog.Logger) (*sessions.Tracker, error) {
+	if *stateDir == "" {
+		return sessions.NewTracker(sessions.NewHistory(*sessionsHistory), logger), nil
+	}
+	history, err := sessions.OpenHistory(filepath.Join(*stateDir, "sessions.jsonl"), *sessionsHistory)
+	if err != nil {
+		return nil, err
+	}
+	return sessions.NewTracker(history, logger), nil
+}
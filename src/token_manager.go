@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+	"github.com/webgrip/twitch_exporter/collector"
+)
+
+const (
+	// Twitch requires apps to validate their tokens every hour.
+	tokenValidateInterval = time.Hour
+	// tokenRefreshMargin is how long before expiry a token is refreshed.
+	tokenRefreshMargin = 10 * time.Minute
+	// tokenRefreshRetry is the delay before retrying a failed refresh.
+	tokenRefreshRetry = time.Minute
+	// tokenFallbackRefresh is used when Twitch did not report an expiry.
+	tokenFallbackRefresh = 24 * time.Hour
+)
+
+var errTokenInvalid = errors.New("token is invalid or expired")
+
+// tokenManager keeps the token of one Helix client fresh. It refreshes shortly
+// before the token expires rather than on a fixed schedule, and validates the
+// token hourly, picking up expiry and scope changes on the way.
+type tokenManager struct {
+	logger    *slog.Logger
+	tokenType string // "app" or "user"
+	client    *helix.Client
+	refreshFn func() (expiresIn int, err error)
+	validator helix.HTTPClient
+
+	mu        sync.Mutex
+	expiresAt time.Time
+	scopes    []string
+	wake      chan struct{}
+	// shared are further clients using the app token of client; they get
+	// every new token.
+	shared []*helix.Client
+	// authorized are called when the token was refreshed or its scopes
+	// changed, which is when a new grant of the user becomes visible.
+	authorized []func()
+}
+
+func newTokenManager(logger *slog.Logger, tokenType string, client *helix.Client, refresh func() (int, error)) *tokenManager {
+	return &tokenManager{
+		logger:    logger.With("token_type", tokenType),
+		tokenType: tokenType,
+		client:    client,
+		refreshFn: refresh,
+		validator: newInstrumentedHTTPClient("id"),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// start refreshes and validates once before returning, so capabilities are
+// known before collectors are created, then keeps the token fresh.
+func (m *tokenManager) start() {
+	ok := m.refresh()
+	m.validate()
+	go m.refreshLoop(!ok)
+	go m.validateLoop()
+}
+
+func (m *tokenManager) refresh() bool {
+	m.logger.Info("Refreshing access token")
+	expiresIn, err := m.refreshFn()
+	if err != nil {
+		collector.IncOAuthRefresh(m.tokenType, "failure")
+		m.logger.Error("Error refreshing access token", "err", err)
+		return false
+	}
+	collector.IncOAuthRefresh(m.tokenType, "success")
+	m.propagate()
+	m.setExpiresIn(expiresIn)
+	m.notifyAuthorized()
+	return true
+}
+
+// share makes client use the app token of this manager from now on.
+func (m *tokenManager) share(client *helix.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client.SetAppAccessToken(m.client.GetAppAccessToken())
+	m.shared = append(m.shared, client)
+}
+
+// propagate hands a refreshed app token to the shared clients.
+func (m *tokenManager) propagate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := m.client.GetAppAccessToken()
+	for _, c := range m.shared {
+		c.SetAppAccessToken(token)
+	}
+}
+
+// onAuthorized registers fn to be called after every refresh and scope change.
+// fn must not block.
+func (m *tokenManager) onAuthorized(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authorized = append(m.authorized, fn)
+}
+
+func (m *tokenManager) notifyAuthorized() {
+	m.mu.Lock()
+	hooks := slices.Clone(m.authorized)
+	m.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// onRefreshed records a refresh done by the helix client itself after a 401.
+func (m *tokenManager) onRefreshed() {
+	collector.IncOAuthRefresh(m.tokenType, "success")
+	m.setExpiresIn(0)
+	m.notifyAuthorized()
+	go m.validate()
+}
+
+func (m *tokenManager) setExpiresIn(expiresIn int) {
+	var expiresAt time.Time
+	if expiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	m.mu.Lock()
+	m.expiresAt = expiresAt
+	m.mu.Unlock()
+	collector.SetOAuthTokenExpiresAt(m.tokenType, expiresAt)
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *tokenManager) nextRefresh() time.Duration {
+	m.mu.Lock()
+	expiresAt := m.expiresAt
+	m.mu.Unlock()
+	if expiresAt.IsZero() {
+		return tokenFallbackRefresh
+	}
+	wait := time.Until(expiresAt) - tokenRefreshMargin
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (m *tokenManager) refreshLoop(failed bool) {
+	for {
+		wait := m.nextRefresh()
+		if failed {
+			wait = tokenRefreshRetry
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.wake:
+			// a valid token with a new expiry was seen, reschedule
+			timer.Stop()
+			failed = false
+			continue
+		case <-timer.C:
+		}
+		failed = !m.refresh()
+	}
+}
+
+func (m *tokenManager) validateLoop() {
+	ticker := time.NewTicker(tokenValidateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.validate()
+	}
+}
+
+func (m *tokenManager) accessToken() string {
+	if m.tokenType == "user" {
+		return m.client.GetUserAccessToken()
+	}
+	return m.client.GetAppAccessToken()
+}
+
+func (m *tokenManager) validate() {
+	token := m.accessToken()
+	if token == "" {
+		return
+	}
+	v, err := validateToken(m.validator, token)
+	if errors.Is(err, errTokenInvalid) {
+		m.logger.Warn("access token failed validation; refreshing")
+		m.refresh()
+		return
+	}
+	if err != nil {
+		m.logger.Warn("failed to validate access token", "err", err)
+		return
+	}
+
+	m.mu.Lock()
+	if v.ExpiresIn > 0 {
+		m.expiresAt = time.Now().Add(time.Duration(v.ExpiresIn) * time.Second)
+		collector.SetOAuthTokenExpiresAt(m.tokenType, m.expiresAt)
+	}
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	if m.tokenType == "user" {
+		m.updateScopes(v)
+	}
+}
+
+// updateScopes publishes the validated user scopes to the capability surface
+// whenever they change, e.g. after the token was re-authorized.
+func (m *tokenManager) updateScopes(v validateTokenResponse) {
+	scopes := slices.Clone(v.Scopes)
+	sort.Strings(scopes)
+
+	m.mu.Lock()
+	changed := m.scopes == nil || !slices.Equal(m.scopes, scopes)
+	m.scopes = scopes
+	m.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	m.logger.Info("user token validated", "login", v.Login, "user_id", v.UserID, "scopes", scopes)
+	collector.SetKnownOAuthScopes(collector.KnownUserScopes, scopes)
+	collector.SetCapabilities(collector.GetCapabilities().AppTokenPresent, true, scopes)
+	m.notifyAuthorized()
+}
+
+type validateTokenResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// validateToken calls the Twitch validate endpoint. A 401 is reported as
+// errTokenInvalid.
+func validateToken(client helix.HTTPClient, accessToken string) (validateTokenResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://id.twitch.tv/oauth2/validate", nil)
+	if err != nil {
+		return validateTokenResponse{}, err
+	}
+	// Twitch validate endpoint expects: Authorization: OAuth <token>
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return validateTokenResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return validateTokenResponse{}, errTokenInvalid
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return validateTokenResponse{}, fmt.Errorf("validate token returned status %d", resp.StatusCode)
+	}
+
+	var v validateTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return validateTokenResponse{}, err
+	}
+	return v, nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	kingpin "github.com/alecthomas/kingpin/v2"
@@ -21,12 +24,22 @@ import (
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	"github.com/webgrip/twitch_exporter/collector"
 	"github.com/webgrip/twitch_exporter/internal/eventsub"
+	"github.com/webgrip/twitch_exporter/internal/tokenstore"
 )
 
 var (
+	// serve is the default command; see auth.go for the others.
+	_ = kingpin.Command("serve", "Run the exporter (default).").Default()
+
 	metricsPath = kingpin.Flag("web.telemetry-path",
 		"Path under which to expose metrics.").
 		Default("/metrics").String()
+	adminTokenFile = kingpin.Flag("web.admin-token-file",
+		"File containing the bearer token for the /api/watchlist admin endpoints. The admin API is disabled when unset.").
+		Default("").String()
+	configFile = kingpin.Flag("config.file",
+		"Path to a YAML config file for the watchlist, reward and category grouping and collector toggles. Reloaded on SIGHUP or POST /-/reload.").
+		Default("").String()
 
 	// twitch app access token config
 	twitchClientID = kingpin.Flag("twitch.client-id",
@@ -39,13 +52,44 @@ var (
 		"Access Token for the Twitch Helix API.").String()
 	twitchRefreshToken = kingpin.Flag("twitch.refresh-token",
 		"Refresh Token for the Twitch Helix API.").String()
+	tokenStoreFile = kingpin.Flag("twitch.token-store.file",
+		"File the user access and refresh tokens are persisted to after every refresh. A stored token takes precedence over --twitch.access-token/--twitch.refresh-token.").Default("").String()
+	tokenStoreExec = kingpin.Flag("twitch.token-store.exec",
+		"Executable that loads (<cmd> get) and stores (<cmd> store) the user tokens as JSON. A stored token takes precedence over --twitch.access-token/--twitch.refresh-token.").Default("").String()
+	apiMaxRetries = kingpin.Flag("twitch.api.max-retries",
+		"Maximum number of retries for idempotent Helix requests answered with 429 or 5xx.").Default("3").Int()
+	apiRateLimitReserve = kingpin.Flag("twitch.api.rate-limit-reserve",
+		"Rate-limit points left unused as a margin; requests wait for the bucket to refill instead.").Default("5").Int()
 	eventSubEnabled = kingpin.Flag("eventsub.enabled",
 		"Enable the Twitch Eventsub API.").Default("false").Bool()
+	eventSubTransport = kingpin.Flag("eventsub.transport",
+		"How EventSub notifications are received: webhook (needs a public HTTPS URL) or websocket (outbound only, needs a user access token).").
+		Default(eventsub.TransportWebhook).Enum(eventsub.TransportWebhook, eventsub.TransportWebSocket)
+	eventSubWebSocketURL = kingpin.Flag("eventsub.websocket-url",
+		"EventSub WebSocket URL to connect to with --eventsub.transport=websocket.").Default(eventsub.DefaultWebSocketURL).String()
 	eventSubWebhookURL = kingpin.Flag("eventsub.webhook-url",
 		"The url your collector will be expected to be hosted at, eg: http://example.svc/eventsub (Must end with `/eventsub`).").Default("").String()
 	eventSubWebhookSecret = kingpin.Flag("eventsub.webhook-secret",
 		"Secure 1-100 character secret for your eventsub validation.").Default("").String()
 
+	eventSubReconcileInterval = kingpin.Flag("eventsub.reconcile-interval",
+		"How often EventSub subscriptions are compared with the desired ones, recreating missing or failed and removing stale ones (0s disables).").Default("5m").Duration()
+	eventSubConduitEnabled = kingpin.Flag("eventsub.conduit.enabled",
+		"Receive EventSub notifications through a conduit; this replica owns one shard using --eventsub.transport. Requires an app access token.").Default("false").Bool()
+	eventSubConduitID = kingpin.Flag("eventsub.conduit.id",
+		"Conduit to adopt. When empty, the app's first conduit is adopted, or one is created.").Default("").String()
+	eventSubConduitShardCount = kingpin.Flag("eventsub.conduit.shard-count",
+		"Minimum number of conduit shards, usually the number of replicas. The conduit is grown to it but never shrunk.").Default("1").Int()
+	eventSubConduitShardID = kingpin.Flag("eventsub.conduit.shard-id",
+		"Conduit shard owned by this replica (0-based).").Default("0").Int()
+
+	stateDir = kingpin.Flag("state.dir",
+		"Directory EventSub-derived counters (follows, bits, subs, raids, moderation actions) are persisted to, so they survive restarts. Empty keeps them in memory only.").Default("").String()
+	stateSnapshotInterval = kingpin.Flag("state.snapshot-interval",
+		"How often the persisted counters are compacted into a snapshot; in between, every change is appended to a write-ahead log.").Default("5m").Duration()
+	sessionsHistory = kingpin.Flag("sessions.history",
+		"Number of finished stream sessions kept per channel for /api/sessions and the last-stream metrics. Persisted in --state.dir when set.").Default("50").Int()
+
 	// collector configs
 	// the twitch channel is a global config for all collectors, and is
 	// defined at the root level. Individual collectors may have their own
@@ -57,7 +101,24 @@ var (
 	twitchSelfChannel = kingpin.Flag("twitch.self-channel",
 		"Your own Twitch channel login (role=self). Required for privileged/self-only metrics.").Default("").String()
 	twitchWatchChannels = Channels(kingpin.Flag("twitch.watch-channel",
-		"A Twitch channel login to watch (role=watch). Can be provided multiple times; max 100."))
+		"A Twitch channel login to watch (role=watch). Can be provided multiple times; see --twitch.watch-channel.max."))
+	twitchWatchChannelsMax = kingpin.Flag("twitch.watch-channel.max",
+		"Maximum number of role=watch channels (0 = unlimited). Helix calls are batched in pages of 100.").
+		Default(strconv.Itoa(collector.DefaultMaxWatchChannels)).Int()
+	twitchAPIConcurrency = kingpin.Flag("twitch.api.concurrency",
+		"Maximum number of concurrent Helix requests per collector.").Default("4").Int()
+	collectorInterval = kingpin.Flag("collector.interval",
+		"Run collectors in the background on this interval and serve cached results on scrape (0 = collect on every scrape).").Default("0s").Duration()
+	channelCoreEventSub = kingpin.Flag("collector.channel_core.eventsub",
+		"Let channel_core also count title, category and live transitions from EventSub notifications (channel.update, stream.online/offline); polling remains the fallback. Requires --eventsub.enabled.").Default("false").Bool()
+	collectorTimeout = kingpin.Flag("collector.timeout",
+		"Maximum time a collector may run (0 = bounded only by the scrape timeout).").Default("0s").Duration()
+	timeoutOffset = kingpin.Flag("web.timeout-offset",
+		"Offset to subtract from the Prometheus scrape timeout in seconds.").Default("0.5").Float64()
+	userResolverTTL = kingpin.Flag("twitch.user-cache.ttl",
+		"How long resolved login to user ID mappings are cached.").Default("15m").Duration()
+	userResolverNegativeTTL = kingpin.Flag("twitch.user-cache.negative-ttl",
+		"How long logins unknown to Twitch are cached before being looked up again.").Default("5m").Duration()
 
 	// reward grouping for channel points redemptions
 	rewardGroupDefault = kingpin.Flag("twitch.reward-group.default",
@@ -71,6 +132,19 @@ var (
 		"Map a channel points reward id to a reward_group label (repeatable). Format: <reward_id>:<group>."))
 	rewardGroupByTitle = KeyValueMap(kingpin.Flag("twitch.reward-group.title",
 		"Map a channel points reward title to a reward_group label (repeatable). Format: <reward_title>:<group>."))
+
+	// category grouping for channel_core
+	categoryGroupDefault = kingpin.Flag("twitch.category-group.default",
+		"Category group label for channels without a category.").Default("none").String()
+	categoryGroupUnknown = kingpin.Flag("twitch.category-group.unknown",
+		"Category group label used when a category is not mapped.").Default("other").String()
+	categoryGroupMax = kingpin.Flag("twitch.category-group.max",
+		"Maximum number of unique category_group label values allowed.").Default("20").Int()
+
+	categoryGroupByID = KeyValueMap(kingpin.Flag("twitch.category-group.id",
+		"Map a category id to a category_group label (repeatable). Format: <category_id>:<group>."))
+	categoryGroupByName = KeyValueMap(kingpin.Flag("twitch.category-group.name",
+		"Map a category name to a category_group label (repeatable). Format: <category_name>:<group>."))
 )
 
 type keyValueMap map[string]string
@@ -130,9 +204,18 @@ func main() {
 	var webConfig = webflag.AddFlags(kingpin.CommandLine, "0.0.0.0:9184")
 	kingpin.Version(version.Print("twitch_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	logger := promslog.New(promslogConfig)
+
+	if command == authLoginCmd.FullCommand() {
+		if err := runAuthLogin(logger); err != nil {
+			logger.Error("auth login failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger.Info("Starting twitch_exporter", "version", version.Info())
 	logger.Info("", "build_context", version.BuildContext())
 
@@ -142,7 +225,25 @@ func main() {
 	})
 
 	var client *helix.Client
-	var err error
+
+	tokenStore, err := newTokenStore()
+	if err != nil {
+		logger.Error("invalid token store configuration", "err", err)
+		os.Exit(1)
+	}
+	if tokenStore != nil {
+		token, err := tokenStore.Load()
+		switch {
+		case err == nil:
+			logger.Info("using user token from token store")
+			*twitchAccessToken = token.AccessToken
+			*twitchRefreshToken = token.RefreshToken
+		case errors.Is(err, tokenstore.ErrNotFound):
+			logger.Info("token store is empty; using user token from flags")
+		default:
+			logger.Warn("failed to load user token from token store; using flags", "err", err)
+		}
+	}
 
 	clientType := "app"
 
@@ -168,18 +269,51 @@ func main() {
 	// Validate user token scopes (if present) so missing scopes are obvious in metrics.
 	validatedScopes := []string{}
 	if userTokenPresent {
-		scopes, err := validateUserTokenScopes(*twitchAccessToken)
+		v, err := validateToken(newInstrumentedHTTPClient("id"), *twitchAccessToken)
 		if err != nil {
 			logger.Warn("failed to validate user token scopes", "err", err)
 		} else {
-			validatedScopes = scopes
+			validatedScopes = v.Scopes
 		}
 	}
 	collector.SetKnownOAuthScopes(collector.KnownUserScopes, validatedScopes)
 	collector.SetCapabilities(appTokenPresent, userTokenPresent, validatedScopes)
 
-	if err := collector.SetRewardGrouping(*rewardGroupDefault, *rewardGroupUnknown, *rewardGroupMax, map[string]string(*rewardGroupByID), map[string]string(*rewardGroupByTitle)); err != nil {
-		logger.Error("invalid reward group configuration", "err", err)
+	collector.SetAPIConcurrency(*twitchAPIConcurrency)
+	collector.SetUserResolverTTL(*userResolverTTL, *userResolverNegativeTTL)
+	if *channelCoreEventSub && !*eventSubEnabled {
+		logger.Warn("--collector.channel_core.eventsub has no effect without --eventsub.enabled")
+	}
+	collector.SetChannelCoreEventSub(*channelCoreEventSub)
+	if err := setupStateStore(logger); err != nil {
+		logger.Error("failed to open state store", "dir", *stateDir, "err", err)
+		os.Exit(1)
+	}
+	tracker, err := newSessionTracker(logger)
+	if err != nil {
+		logger.Error("failed to open stream session history", "dir", *stateDir, "err", err)
+		os.Exit(1)
+	}
+	collector.SetSessionTracker(tracker)
+	collector.SetHelixContextBinder(bindHelixContext)
+
+	fileCfg, err := loadConfigFile(*configFile)
+	if err != nil {
+		logger.Error("failed to load config file", "file", *configFile, "err", err)
+		os.Exit(1)
+	}
+	if len(*twitchChannel) > 1 && strings.TrimSpace(*twitchSelfChannel) == "" {
+		logger.Warn("multiple --twitch.channel values detected; treating first as self and the rest as watch; prefer --twitch.self-channel/--twitch.watch-channel")
+	}
+	runtimeCfg, err := resolveConfig(fileCfg)
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	collector.ApplyRewardGrouping(runtimeCfg.rewardGrouping)
+	collector.ApplyCategoryGrouping(runtimeCfg.categoryGrouping)
+	if err := collector.SetCollectorOverrides(runtimeCfg.collectors); err != nil {
+		logger.Error("invalid collector configuration", "err", err)
 		os.Exit(1)
 	}
 
@@ -196,7 +330,7 @@ func main() {
 				client = nil
 			}
 		case "user":
-			client, err = newClientWithUserAccessToken(logger, newInstrumentedHTTPClient("helix"))
+			client, err = newClientWithUserAccessToken(logger, newInstrumentedHTTPClient("helix"), tokenStore)
 			if err != nil {
 				logger.Error("Error creating the client", "err", err)
 				collector.DisableDefaultCollectors()
@@ -207,88 +341,11 @@ func main() {
 	}
 
 	var eventsubClient *eventsub.Client
-
 	if *eventSubEnabled {
-		if client == nil {
-			logger.Error("eventsub enabled but Twitch client is not configured; disabling eventsub")
-		} else {
-			logger.Info("eventsub endpoint enabled", "endpoint", "/eventsub")
-
-			var appClient *helix.Client
-
-			// eventsub requires an app client to create webhooks, but we may have created a user client
-			// beforehand for subscription metrics, so just check and create the app client if needed
-			if clientType == "user" {
-				appClient, err = newClientWithSecret(logger, newInstrumentedHTTPClient("eventsub"))
-				if err != nil {
-					logger.Error("Error creating the client", "err", err)
-					os.Exit(1)
-				}
-			} else {
-				// Create a dedicated client so EventSub subscription management is tracked separately.
-				appClient, err = newClientWithSecret(logger, newInstrumentedHTTPClient("eventsub"))
-				if err != nil {
-					logger.Error("Error creating eventsub client", "err", err)
-					appClient = nil
-				}
-			}
-
-			if *eventSubWebhookURL == "" || *eventSubWebhookSecret == "" {
-				logger.Error("eventsub enabled but webhook URL/secret are missing; disabling eventsub")
-				appClient = nil
-			}
-			if appClient != nil {
-
-				var userClient *helix.Client
-				if clientType == "user" {
-					userClient = client
-				}
-
-				eventsubClient, err = eventsub.New(
-					*twitchClientID,
-					*twitchClientSecret,
-					*eventSubWebhookURL,
-					*eventSubWebhookSecret,
-					logger,
-					appClient,
-					userClient,
-				)
-
-				if err != nil {
-					logger.Error("Error creating the eventsub client", "err", err)
-					eventsubClient = nil
-				} else {
-					eventsubClient.SetSignatureFailureHook(func(reason string) {
-						collector.IncEventSubSignatureFail(reason)
-					})
-					// expose the eventsub endpoint
-					http.HandleFunc("/eventsub", eventsubClient.Handler())
-				}
-			}
-		}
+		eventsubClient = newEventSubClient(logger, client, clientType)
 	}
 
-	selfLogin := strings.TrimSpace(*twitchSelfChannel)
-	legacyLogins := (*twitchChannel)
-	if selfLogin == "" && len(legacyLogins) > 0 {
-		// Backwards-compatible default: treat first legacy channel as self.
-		selfLogin = legacyLogins[0]
-		if len(legacyLogins) > 1 {
-			logger.Warn("multiple --twitch.channel values detected; treating first as self and the rest as watch; prefer --twitch.self-channel/--twitch.watch-channel")
-		}
-	}
-
-	watchLogins := make([]string, 0, len(*twitchWatchChannels)+max(0, len(legacyLogins)-1))
-	watchLogins = append(watchLogins, (*twitchWatchChannels)...)
-	if len(legacyLogins) > 1 {
-		watchLogins = append(watchLogins, legacyLogins[1:]...)
-	}
-
-	watchlist, wlErr := collector.NewChannelWatchlist(selfLogin, watchLogins)
-	if wlErr != nil {
-		logger.Error("invalid watchlist configuration", "err", wlErr)
-		os.Exit(1)
-	}
+	watchlist := collector.NewSharedWatchlist(runtimeCfg.watchlist)
 
 	exporter, err := collector.NewExporter(logger, client, eventsubClient, watchlist)
 	if err != nil {
@@ -301,14 +358,60 @@ func main() {
 		}
 	}
 
+	if err := exporter.SetTimeouts(*collectorTimeout, runtimeCfg.timeouts); err != nil {
+		logger.Error("invalid collector timeout configuration", "err", err)
+		os.Exit(1)
+	}
+	if err := exporter.SetIntervals(*collectorInterval, runtimeCfg.intervals); err != nil {
+		logger.Error("invalid collector interval configuration", "err", err)
+		os.Exit(1)
+	}
+
+	modules := &probeModules{}
+	modules.set(runtimeCfg.modules)
+
+	reload := newReloader(logger, *configFile, exporter, modules)
+	reload.markLoaded()
+	if *configFile != "" {
+		reload.watchSignals()
+	}
+
 	r := prometheus.NewRegistry()
 	r.MustRegister(configured)
-	r.MustRegister(exporter)
+	r.MustRegister(reload.collectors()...)
+
+	http.HandleFunc(*metricsPath, func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := scrapeContext(req, *timeoutOffset)
+		defer cancel()
+
+		// The exporter is registered per request so its collectors are
+		// bound to this scrape's deadline.
+		scrape := prometheus.NewRegistry()
+		scrape.MustRegister(exporter.WithContext(ctx))
+		promhttp.HandlerFor(prometheus.Gatherers{r, scrape}, promhttp.HandlerOpts{
+			ErrorLog:      promHTTPLogger{logger: logger},
+			ErrorHandling: promhttp.ContinueOnError,
+		}).ServeHTTP(w, req)
+	})
+
+	http.HandleFunc("/-/reload", reload.Handler())
 
-	http.Handle(*metricsPath, promhttp.HandlerFor(r, promhttp.HandlerOpts{
-		ErrorLog:      promHTTPLogger{logger: logger},
-		ErrorHandling: promhttp.ContinueOnError,
-	}))
+	probe := &prober{logger: logger, client: client, watchlist: watchlist, modules: modules, timeoutOffset: *timeoutOffset}
+	http.HandleFunc("/probe", probe.Handler())
+
+	sessionsAPI := &sessionsAPI{tracker: tracker}
+	http.HandleFunc("GET /api/sessions", sessionsAPI.list)
+
+	if *adminTokenFile != "" {
+		token, err := loadAdminToken(*adminTokenFile)
+		if err != nil {
+			logger.Error("failed to load admin token", "err", err)
+			os.Exit(1)
+		}
+		api := &watchlistAPI{logger: logger, token: token, watchlist: watchlist}
+		api.register(http.DefaultServeMux)
+		logger.Info("watchlist admin API enabled", "path", "/api/watchlist")
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte(`<html>
@@ -332,69 +435,115 @@ func main() {
 	}
 }
 
-type validateTokenResponse struct {
-	ClientID  string   `json:"client_id"`
-	Login     string   `json:"login"`
-	UserID    string   `json:"user_id"`
-	Scopes    []string `json:"scopes"`
-	ExpiresIn int      `json:"expires_in"`
+// scrapeContext derives a context from the X-Prometheus-Scrape-Timeout-Seconds
+// header, leaving offset seconds to write the response.
+func scrapeContext(r *http.Request, offset float64) (context.Context, context.CancelFunc) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	if seconds > offset {
+		seconds -= offset
+	}
+	return context.WithTimeout(r.Context(), time.Duration(seconds*float64(time.Second)))
 }
 
-func validateUserTokenScopes(accessToken string) ([]string, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://id.twitch.tv/oauth2/validate", nil)
+// refreshAppAccessToken requests a new app access token and returns its
+// lifetime in seconds.
+func refreshAppAccessToken(client *helix.Client) (int, error) {
+	appAccessToken, err := client.RequestAppAccessToken([]string{})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	// Twitch validate endpoint expects: Authorization: OAuth <token>
-	req.Header.Set("Authorization", "OAuth "+accessToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("validate token returned status %d", resp.StatusCode)
+	if appAccessToken.ErrorStatus != 0 {
+		return 0, errors.New(appAccessToken.ErrorMessage)
 	}
 
-	var v validateTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
-		return nil, err
-	}
+	client.SetAppAccessToken(appAccessToken.Data.AccessToken)
+	return appAccessToken.Data.ExpiresIn, nil
+}
 
-	return v.Scopes, nil
+// newTokenStore returns the configured token store, or nil if none is set.
+func newTokenStore() (tokenstore.Store, error) {
+	switch {
+	case *tokenStoreFile != "" && *tokenStoreExec != "":
+		return nil, errors.New("--twitch.token-store.file and --twitch.token-store.exec are mutually exclusive")
+	case *tokenStoreFile != "":
+		return tokenstore.NewFileStore(*tokenStoreFile), nil
+	case *tokenStoreExec != "":
+		return tokenstore.NewExecStore(*tokenStoreExec), nil
+	}
+	return nil, nil
 }
 
-func refreshAppAccessToken(logger *slog.Logger, client *helix.Client) {
-	logger.Info("Refreshing app access token")
-	appAccessToken, err := client.RequestAppAccessToken([]string{})
-	if err != nil {
-		logger.Error("Error getting app access token", "err", err)
+// persistUserToken writes refreshed tokens back to the store so a rotated
+// refresh token survives a restart.
+func persistUserToken(logger *slog.Logger, store tokenstore.Store, token tokenstore.Token) {
+	if store == nil {
 		return
 	}
-
-	if appAccessToken.ErrorStatus != 0 {
-		logger.Error("Error getting app access token", "err", appAccessToken.ErrorMessage)
+	if err := store.Save(token); err != nil {
+		logger.Error("failed to persist user token", "err", err)
 		return
 	}
-
-	client.SetAppAccessToken(appAccessToken.Data.AccessToken)
+	logger.Debug("persisted user token")
 }
 
-func refreshUserAccessToken(logger *slog.Logger, client *helix.Client) {
-	logger.Info("Refreshing user access token")
+// refreshUserAccessToken exchanges the refresh token for a new user access
+// token, persists the pair and returns the access token's lifetime in seconds.
+func refreshUserAccessToken(logger *slog.Logger, client *helix.Client, store tokenstore.Store) (int, error) {
 	userAccessToken, err := client.RefreshUserAccessToken(client.GetRefreshToken())
 	if err != nil {
-		logger.Error("Error getting user access token", "err", err)
-		return
+		return 0, err
 	}
 
 	if userAccessToken.ErrorStatus != 0 {
-		logger.Error("Error getting user access token", "err", userAccessToken.ErrorMessage)
-		return
+		return 0, errors.New(userAccessToken.ErrorMessage)
 	}
 
+	// Twitch may rotate the refresh token; the old one stops working.
 	client.SetUserAccessToken(userAccessToken.Data.AccessToken)
+	if userAccessToken.Data.RefreshToken != "" {
+		client.SetRefreshToken(userAccessToken.Data.RefreshToken)
+	}
+
+	token := tokenstore.Token{
+		AccessToken:  userAccessToken.Data.AccessToken,
+		RefreshToken: client.GetRefreshToken(),
+		Scopes:       userAccessToken.Data.Scopes,
+	}
+	if userAccessToken.Data.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(userAccessToken.Data.ExpiresIn) * time.Second)
+	}
+	persistUserToken(logger, store, token)
+	return userAccessToken.Data.ExpiresIn, nil
+}
+
+// appTokens is the manager of the app access token. Every app client shares
+// the one token, so a single manager refreshes it and reports its expiry.
+// userTokens is the manager of the user access token, nil without one.
+var (
+	tokensMu   sync.Mutex
+	appTokens  *tokenManager
+	userTokens *tokenManager
+)
+
+// tokenManagers returns the managers created so far.
+func tokenManagers() []*tokenManager {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	var managers []*tokenManager
+	for _, m := range []*tokenManager{appTokens, userTokens} {
+		if m != nil {
+			managers = append(managers, m)
+		}
+	}
+	return managers
 }
 
 // newClientWithSecret creates a new Twitch client with the use of an app access
@@ -410,22 +559,25 @@ func newClientWithSecret(logger *slog.Logger, httpClient helix.HTTPClient) (*hel
 		logger.Error("could not initialise twitch client", "err", err)
 		return nil, err
 	}
+	registerHelixClient(client, httpClient, nil)
 
-	refreshAppAccessToken(logger, client)
-
-	refreshTicker := time.NewTicker(24 * time.Hour)
-	go func(logger *slog.Logger, refreshTicker *time.Ticker, client *helix.Client) {
-		for range refreshTicker.C {
-			refreshAppAccessToken(logger, client)
-		}
-	}(logger, refreshTicker, client)
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	if appTokens != nil {
+		appTokens.share(client)
+		return client, nil
+	}
+	appTokens = newTokenManager(logger, "app", client, func() (int, error) {
+		return refreshAppAccessToken(client)
+	})
+	appTokens.start()
 
 	return client, nil
 }
 
 // newClientWithUserAccessToken creates a new Twitch client with a user access token.
 // this is required for private data, such as subscriber counts.
-func newClientWithUserAccessToken(logger *slog.Logger, httpClient helix.HTTPClient) (*helix.Client, error) {
+func newClientWithUserAccessToken(logger *slog.Logger, httpClient helix.HTTPClient, store tokenstore.Store) (*helix.Client, error) {
 	// providing a refresh token allows the helix client to refresh the access
 	// token when it expires. this is done automatically when using the helix
 	// client.
@@ -442,54 +594,29 @@ func newClientWithUserAccessToken(logger *slog.Logger, httpClient helix.HTTPClie
 		return nil, err
 	}
 
+	manager := newTokenManager(logger, "user", client, func() (int, error) {
+		return refreshUserAccessToken(logger, client, store)
+	})
+
+	// the helix client refreshes on its own when a request returns 401
+	onRefreshed := func(newAccessToken, newRefreshToken string) {
+		persistUserToken(logger, store, tokenstore.Token{AccessToken: newAccessToken, RefreshToken: newRefreshToken})
+		manager.onRefreshed()
+	}
+	client.OnUserAccessTokenRefreshed(onRefreshed)
+	registerHelixClient(client, httpClient, onRefreshed)
+
 	// it may be redundant to refresh the access token here, but it's done
 	// anyway to ensure the access token is always valid, in case the parameters
 	// are outdated
-	refreshUserAccessToken(logger, client)
-
-	refreshTicker := time.NewTicker(24 * time.Hour)
-	go func(logger *slog.Logger, refreshTicker *time.Ticker, client *helix.Client) {
-		for range refreshTicker.C {
-			refreshUserAccessToken(logger, client)
-		}
-	}(logger, refreshTicker, client)
+	manager.start()
+	tokensMu.Lock()
+	userTokens = manager
+	tokensMu.Unlock()
 
 	return client, nil
 }
 
-type instrumentedHTTPClient struct {
-	api   string
-	inner *http.Client
-}
-
-func newInstrumentedHTTPClient(api string) helix.HTTPClient {
-	return &instrumentedHTTPClient{
-		api: api,
-		inner: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
-}
-
-func (c *instrumentedHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	resp, err := c.inner.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	collector.ObserveAPIResponse(c.api, endpointLabel(req.URL.Path), resp.StatusCode, resp.Header)
-	return resp, nil
-}
-
-func endpointLabel(path string) string {
-	path = strings.TrimSpace(path)
-	path = strings.TrimPrefix(path, "/")
-	if path == "" {
-		return "/"
-	}
-	// Keep it bounded: paths are stable, IDs are in query params for Helix.
-	return path
-}
-
 func max(a, b int) int {
 	if a > b {
 		return a